@@ -1,14 +1,13 @@
 package app
 
 import (
-	"elevator-project/pkg/config"
 	"elevator-project/pkg/drivers"
 	"strconv"
 )
 
-func convertOrderDataToButtonEvents(orderData map[string][][2]bool) []drivers.ButtonEvent {
+func (n *Node) convertOrderDataToButtonEvents(orderData map[string][][2]bool) []drivers.ButtonEvent {
 	var events []drivers.ButtonEvent
-	orders := orderData[strconv.Itoa(config.ElevatorID)]
+	orders := orderData[strconv.Itoa(n.ID)]
 
 	for floor, calls := range orders {
 		if calls[0] { // Hall up call
@@ -1,231 +1,557 @@
 package app
 
 import (
+	"context"
 	"elevator-project/pkg/HRA"
+	"elevator-project/pkg/clock"
 	"elevator-project/pkg/config"
 	"elevator-project/pkg/drivers"
 	"elevator-project/pkg/elevator"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/failure"
+	"elevator-project/pkg/lamps"
+	"elevator-project/pkg/logging"
 	"elevator-project/pkg/message"
+	"elevator-project/pkg/metrics"
 	"elevator-project/pkg/network/peers"
+	"elevator-project/pkg/network/unicast"
+	"elevator-project/pkg/record"
 	"elevator-project/pkg/state"
 	"fmt"
+	"log/slog"
+	"net"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var IsMaster bool = false
-var CurrentMasterID int = 1
-var masterStateStore = state.NewStore()
-var msgID = &message.MsgID{}
-var Peers peers.PeerUpdate //to maintain elevators in network
-
-func MessageHandler(msgRx chan message.Message, ackChan chan message.Message, msgTx chan message.Message, elevatorFSM *elevator.Elevator) {
-	for msg := range msgRx {
-		//if msg.ElevatorID != config.ElevatorID {
-		switch msg.Type {
-		case message.Ack:
-			//TODO: check if ack is on correct msg
-			if msg.AckID == msgID.Get() {
-				fmt.Printf("Received ACK: %#v\n", msg)
-				ackChan <- msg
-			}
+var log = logging.For("app")
+
+// Node is one elevator node: the local elevator, the node's view of the
+// cluster, and the goroutines keeping the two in sync with the other nodes.
+// cmd/main runs one node per process, the simulator runs several in one.
+type Node struct {
+	ID              int
+	IsMaster        bool
+	CurrentMasterID int
+	// CurrentTerm counts the master changes of the cluster. The node that
+	// names a new master starts the next term, and the others take it from
+	// the MasterSlaveConfig. It is attached to every log line of the node so
+	// the logs of different masters can be told apart.
+	CurrentTerm int
+	// masterMu guards the writes of IsMaster, CurrentMasterID, CurrentTerm
+	// and logger, which MessageHandler makes, for the reads of Master and
+	// log.
+	masterMu sync.Mutex
+	logger   *slog.Logger // Attaches the node ID and CurrentTerm
+
+	// MsgTx carries the messages this node sends, MsgRx the messages it
+	// receives.
+	MsgTx chan message.Message
+	MsgRx chan message.Message
+
+	elevator       *elevator.Elevator
+	store          *state.Store
+	msgID          *message.MsgID
+	ackChan        chan message.Message
+	lampController *lamps.Controller
+	clk            clock.Clock
+	// pendingOrders holds the time each not yet completed order was first
+	// seen, for the order wait time histogram. Only used from MessageHandler.
+	pendingOrders map[string]time.Time
+	// handover is set while this node, as a new master, collects the
+	// worldviews of the other nodes. Only used from MessageHandler.
+	handover *handover
+	// assignment is the last hall order assignment of this node as master,
+	// and hallDoneAt when each hall order was last completed or cancelled.
+	// Only used from MessageHandler.
+	assignment map[string][][2]bool
+	hallDoneAt map[drivers.ButtonEvent]time.Time
+	// worldview is the version state of this node's worldview broadcasts.
+	// worldviewVersions holds the worldview version known of each other
+	// elevator, and keyframeRequests when a keyframe was last asked of it.
+	// The maps are only used from MessageHandler.
+	worldview         worldviewSender
+	worldviewVersions map[int]int
+	keyframeRequests  map[int]time.Time
+	// uniTx carries directed messages to the unicast transmitter, book
+	// holds the addresses it sends them to, and unicastAddr is where this
+	// node receives them. All are nil unless SetUnicast has been called.
+	uniTx       chan<- unicast.Packet
+	book        *unicast.AddressBook
+	unicastAddr net.Addr
+	// incarnation tells this start of the node from earlier ones. beacons
+	// carries the beacons published by MessageHandler, and lastBeacon is the
+	// last one published.
+	incarnation int64
+	beacons     chan peers.Beacon
+	lastBeacon  peers.Beacon
+	// liveness carries the failure detector events from P2Pmonitor to
+	// MessageHandler.
+	liveness chan failure.Event
+	// peerUpdate is the last peer update, set by P2Pmonitor. See Peers.
+	peersMu    sync.Mutex
+	peerUpdate peers.PeerUpdate
+	// maxProtocol is the highest protocol version this node speaks, and
+	// protocol the one it sends in. incompatiblePeers holds the peers
+	// without a version in common, only used from P2Pmonitor.
+	maxProtocol       int
+	protocol          atomic.Int64
+	incompatiblePeers map[string]bool
+	metrics           *Metrics
+	// wg counts the goroutines started by Start and Connect.
+	wg sync.WaitGroup
+	// departures holds the MsgIDs of the Departure and Drain messages sent
+	// by Shutdown and Drain, and handedOff is closed when the master has
+	// answered one.
+	departuresMu  sync.Mutex
+	departures    map[int]bool
+	handedOff     chan struct{}
+	handedOffOnce sync.Once
+	// drainRequested is closed by RequestDrain.
+	drainRequested chan struct{}
+	drainOnce      sync.Once
+}
 
-		case message.OrderDelegation:
-			orderData := msg.OrderData
+// Inputs are the channels the driver inputs of a node arrive on.
+type Inputs struct {
+	Buttons     <-chan drivers.ButtonEvent
+	Floors      <-chan int
+	Obstruction <-chan bool
+	Stop        <-chan bool
+}
 
-			myOrderData := orderData[strconv.Itoa(config.ElevatorID)]
-			fmt.Println("My new hallorder are: ")
-			for floor, arr := range myOrderData {
-				fmt.Printf("  Floor %d: Up: %t, Down: %t\n", floor, arr[0], arr[1])
-			}
+// NewNode creates node id. Node 1 starts out as master. The node's tickers,
+// timeouts and timestamps use clk.
+func NewNode(id int, clk clock.Clock) *Node {
+	store := state.NewStore()
+	store.SetClock(clk)
+	n := &Node{
+		ID:                id,
+		CurrentMasterID:   1,
+		IsMaster:          id == 1,
+		MsgTx:             make(chan message.Message, 64),
+		MsgRx:             make(chan message.Message),
+		store:             store,
+		msgID:             &message.MsgID{},
+		ackChan:           make(chan message.Message, 16),
+		clk:               clk,
+		pendingOrders:     make(map[string]time.Time),
+		hallDoneAt:        make(map[drivers.ButtonEvent]time.Time),
+		worldviewVersions: make(map[int]int),
+		keyframeRequests:  make(map[int]time.Time),
+		departures:        make(map[int]bool),
+		handedOff:         make(chan struct{}),
+		drainRequested:    make(chan struct{}),
+		incarnation:       clk.Now().UnixNano(),
+		beacons:           make(chan peers.Beacon, 1),
+		liveness:          make(chan failure.Event, 16),
+		maxProtocol:       highestProtocol(),
+		incompatiblePeers: make(map[string]bool),
+		metrics:           NewMetrics(),
+	}
+	n.logger = n.loggerFor(n.CurrentTerm)
+	n.protocol.Store(message.MinProtocolVersion)
+	n.metrics.currentMaster.Set(float64(n.CurrentMasterID))
+	return n
+}
 
-			events := convertOrderDataToButtonEvents(orderData)
-			for _, event := range events {
-				elevatorFSM.Orders <- event
-			}
+// Metrics returns the registry of the metrics of this node.
+func (n *Node) Metrics() *metrics.Registry {
+	return n.metrics.Registry
+}
 
-			//TODO: Handle new order, add to internal request matrix and send ACK back to master
-			//fmt.Printf("Received Order: %#v, sending ACK...\n", msg)
-			ackMsg := message.Message{
-				Type:       message.Ack,
-				ElevatorID: config.ElevatorID,
-				MsgID:      msgID.Get(),
-				AckID:      msg.MsgID,
-			}
+// MsgCounter returns the MsgID counter of this node. Every message the node
+// sends takes its MsgID from this counter, so receivers can detect gaps.
+func (n *Node) MsgCounter() *message.MsgID {
+	return n.msgID
+}
 
-			msgTx <- ackMsg
-			elevatorFSM.SetHallLigths(masterStateStore.HallRequests)
-
-		case message.CompletedOrder:
-			//TODO: Notify
-			fmt.Printf("Order has been completed: Floor: %d, ButtonType: %d\n", msg.ButtonEvent.Floor, int(msg.ButtonEvent.Button))
-			masterStateStore.ClearOrder(msg.ButtonEvent, msg.ElevatorID)
-			//elevatorFSM.SetHallLigths(masterStateStore.GetHallOrders(config.ElevatorID))
-			elevatorFSM.SetHallLigths(masterStateStore.HallRequests)
-
-		case message.ButtonEvent:
-
-			if IsMaster {
-				if msg.ButtonEvent.Button != drivers.BT_Cab {
-					masterStateStore.SetHallRequest(msg.ButtonEvent)
-					newOrder, _ := HRA.HRARun(masterStateStore)
-					orderMsg := message.Message{
-						Type:       message.OrderDelegation,
-						ElevatorID: config.ElevatorID,
-						MsgID:      msgID.Next(),
-						AckID:      msg.MsgID,
-						OrderData:  newOrder,
-					}
+// SetElevator attaches the local elevator and creates the controller that
+// owns the button lamps on hw. Hall lamps follow the confirmed hall requests
+// and cab lamps follow the cab requests accepted by the elevator.
+func (n *Node) SetElevator(e *elevator.Elevator, hw drivers.Elevio) {
+	n.elevator = e
+	n.lampController = lamps.NewController(config.NumFloors, hw, n.store.GetConfirmedHallRequests, e.CabRequests)
+	n.lampController.SetClock(n.clk)
+}
 
-					msgTx <- orderMsg
-				}
+func (n *Node) Elevator() *elevator.Elevator {
+	return n.elevator
+}
 
-			}
+// ConfirmedHallRequests returns the hall requests this node lights lamps for.
+func (n *Node) ConfirmedHallRequests() [][2]bool {
+	return n.store.GetConfirmedHallRequests()
+}
 
-		case message.Heartbeat:
-			masterStateStore.UpdateHeartbeat(msg.ElevatorID)
-
-		case message.State:
-			status := state.ElevatorStatus{
-				ElevatorID:      msg.ElevatorID,
-				State:           msg.StateData.State,
-				Direction:       msg.StateData.Direction,
-				CurrentFloor:    msg.StateData.CurrentFloor,
-				TravelDirection: msg.StateData.TravelDirection,
-				RequestMatrix:   msg.StateData.RequestMatrix,
-				LastUpdated:     msg.StateData.LastUpdated,
-			}
-			masterStateStore.UpdateStatus(status)
-
-		case message.MasterSlaveConfig:
-			// Update our view of the current master.
-			fmt.Printf("Received master config update: new master is elevator %d\n", msg.ElevatorID)
-			CurrentMasterID = msg.ElevatorID
-			if config.ElevatorID != msg.ElevatorID {
-				IsMaster = false
-			} else {
-				IsMaster = true
-			}
+// Start starts the goroutines of the node: the message handler, the
+// worldview broadcast, the elevator, the lamps and the driver inputs. They run until
+// ctx is done. SetElevator must have been called. A node starting out as
+// master collects the worldviews of the others before it delegates.
+func (n *Node) Start(ctx context.Context, inputs Inputs) {
+	if n.IsMaster {
+		n.beginHandover()
+	}
+	n.spawn(func() { n.MessageHandler(ctx) })
+	n.spawn(func() { n.elevator.Run(ctx) })
+	n.spawn(func() { n.lampController.Run(ctx, config.LampSyncInterval) })
+	n.spawn(func() { n.MonitorSystemInputs(ctx, inputs) })
+	n.spawn(func() { n.StartWorldviewBC(ctx) })
+}
 
-		default:
-			fmt.Printf("Received message: %#v\n", msg)
+// SetUnicast makes the node send directed messages, such as Acks, on uniTx
+// instead of broadcasting them, and keep the addresses of its peers in book.
+// addr is where the node receives directed messages, announced in its
+// beacons. It must be called before Connect.
+func (n *Node) SetUnicast(uniTx chan<- unicast.Packet, book *unicast.AddressBook, addr net.Addr) {
+	n.uniTx = uniTx
+	n.book = book
+	n.unicastAddr = addr
+}
+
+// Connect forwards the node's messages to netTx and from netRx, and follows
+// the peer updates on peerUpdates, until ctx is done.
+func (n *Node) Connect(ctx context.Context, netTx chan<- message.Message, netRx <-chan message.Message, peerUpdates <-chan peers.PeerUpdate) {
+	n.spawn(func() { ForwardOutgoing(ctx, n.MsgTx, netTx, n.uniTx, n.Protocol, n.metrics) })
+	n.spawn(func() { ForwardIncoming(ctx, netRx, n.MsgRx, n.maxProtocol, n.metrics, n.log) })
+	n.spawn(func() { n.P2Pmonitor(ctx, peerUpdates) })
+}
+
+func (n *Node) spawn(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+// Wait waits for the goroutines of the node to return.
+func (n *Node) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Node) triggerLamps() {
+	if n.lampController != nil {
+		n.lampController.Trigger()
+	}
+}
+
+func (n *Node) MessageHandler(ctx context.Context) {
+	snapshots := n.clk.NewTicker(config.SnapshotInterval)
+	defer snapshots.Stop()
+
+	for {
+		select {
+		case msg := <-n.MsgRx:
+			n.HandleMessage(msg)
+		case <-n.handoverTimer():
+			n.HandoverTimerElapsed()
+		case <-snapshots.C():
+			n.SnapshotTimerElapsed()
+		case e := <-n.liveness:
+			n.HandleLiveness(e)
+		case <-ctx.Done():
+			return
 		}
+		n.publishBeacon()
 	}
-	//}
 }
 
-func StartHeartbeatBC(msgTx chan message.Message) {
-	ticker := time.NewTicker(config.HeartBeatInterval)
+var _ message.Handler = (*Node)(nil)
+
+// HandleMessage handles a single message received from the network, with the
+// Handle method of its payload type.
+func (n *Node) HandleMessage(msg message.Message) {
+	record.AddMessage(msg)
+	if err := message.Dispatch(msg, n); err != nil {
+		n.log().Warn("dropping message", "err", err, "from", msg.ElevatorID, "msgID", msg.MsgID)
+	}
+}
 
-	for range ticker.C {
-		hbMsg := message.Message{
-			Type:       message.Heartbeat,
-			ElevatorID: config.ElevatorID,
-			MsgID:      msgID.Next(),
+func (n *Node) HandleAck(msg message.Message, ack message.Ack) {
+	//TODO: check if ack is on correct msg
+	if n.IsMaster && msg.ElevatorID != n.ID {
+		eventlog.Record(eventlog.Event{Kind: eventlog.AckReceived, Elevator: msg.ElevatorID, MsgID: ack.AckID})
+	}
+	if ack.AckID == n.msgID.Get() {
+		n.log().Debug("received ack", "from", msg.ElevatorID, "msgID", msg.MsgID, "ackID", ack.AckID)
+		select {
+		case n.ackChan <- msg:
+		default:
 		}
-		msgTx <- hbMsg
 	}
 }
 
-func StartWorldviewBC(e *elevator.Elevator, msgTx chan message.Message, counter *message.MsgID) {
-	ticker := time.NewTicker(config.WorldviewBCInterval)
-	defer ticker.Stop()
+func (n *Node) HandleOrderDelegation(msg message.Message, delegation message.OrderDelegation) {
+	orderData := delegation.Orders
 
-	for range ticker.C {
-		status := e.GetStatus()
-		masterStateStore.UpdateStatus(status)
-		stateMsg := message.Message{
-			Type:       message.State,
-			ElevatorID: status.ElevatorID,
-			MsgID:      counter.Next(),
-			StateData: &message.ElevatorState{
-				ElevatorID:      status.ElevatorID,
-				State:           status.State,
-				CurrentFloor:    status.CurrentFloor,
-				TravelDirection: status.TravelDirection,
-				LastUpdated:     time.Now(),
-				RequestMatrix:   status.RequestMatrix,
-			},
+	myOrderData := orderData[strconv.Itoa(n.ID)]
+	n.log().Info("received hall orders", "from", msg.ElevatorID, "msgID", msg.MsgID, "orders", myOrderData)
+
+	events := n.convertOrderDataToButtonEvents(orderData)
+	for _, event := range events {
+		n.takeOrder(event)
+	}
+	n.releaseHallOrders(orderData)
+
+	//TODO: Handle new order, add to internal request matrix and send ACK back to master
+	// Get would give the Ack the MsgID of the next message as well.
+	ackMsg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.Ack{AckID: msg.MsgID},
+		To:         []int{msg.ElevatorID},
+	}
+
+	n.MsgTx <- ackMsg
+	n.store.ConfirmHallRequests(orderData)
+	n.triggerLamps()
+	if n.isDeparture(delegation.AckID) {
+		n.handedOffOnce.Do(func() { close(n.handedOff) })
+	}
+}
+
+// releaseHallOrders drops the hall orders of this elevator, if it is out of
+// service, that the master has given to other elevators, so they are not
+// served twice. Those the master could not give to anyone are kept.
+func (n *Node) releaseHallOrders(orderData map[string][][2]bool) {
+	if status, ok := n.store.GetAll()[n.ID]; !ok || status.InService {
+		return
+	}
+	for floor, dirs := range n.elevator.HallRequests() {
+		for dir, active := range dirs {
+			if active && assignedElsewhere(orderData, n.ID, floor, dir) {
+				n.log().Info("dropping hall order moved off this elevator", "floor", floor, "dir", dir)
+				n.elevator.CancelOrder(drivers.ButtonEvent{Floor: floor, Button: drivers.ButtonType(dir)})
+			}
+		}
+	}
+}
+
+// assignedElsewhere reports whether orderData gives the hall order at floor
+// and dir to an elevator other than id.
+func assignedElsewhere(orderData map[string][][2]bool, id, floor, dir int) bool {
+	for other, orders := range orderData {
+		if other != strconv.Itoa(id) && floor < len(orders) && orders[floor][dir] {
+			return true
 		}
+	}
+	return false
+}
 
-		msgTx <- stateMsg
+// takeOrder gives a hall order to the elevator without blocking the message
+// handler. If the order queue is full the order is dropped, and taken again
+// from the next snapshot.
+func (n *Node) takeOrder(be drivers.ButtonEvent) {
+	if !n.elevator.TryOrder(be) {
+		n.log().Warn("order queue full, dropping hall order until the next snapshot", "floor", be.Floor, "button", be.Button)
 	}
 }
 
-func DebugPrintStateStore() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		fmt.Println("----- Current Elevator States -----")
-		statuses := masterStateStore.GetAll()
-		for id, status := range statuses {
-			fmt.Printf("Elevator %d:\n", id)
-			fmt.Printf("  ElevatorID   : %d\n", status.ElevatorID)
-			fmt.Printf("  State        : %d\n", status.State)
-			fmt.Printf("  Direction    : %d\n", status.Direction)
-			fmt.Printf("  CurrentFloor : %d\n", status.CurrentFloor)
-			fmt.Printf("  TravelingDirection  : %d\n", status.TravelDirection)
-			fmt.Printf("  LastUpdated  : %v\n", status.LastUpdated.Format("15:04:05"))
-			fmt.Printf("  HallRequests : %+v\n", status.RequestMatrix.HallRequests)
-			fmt.Printf("  CabRequests  : %+v\n", status.RequestMatrix.CabRequests)
-			fmt.Println()
+func (n *Node) HandleCompletedOrder(msg message.Message, completed message.CompletedOrder) {
+	//TODO: Notify
+	be := completed.Event
+	n.log().Info("order completed", "elevator", msg.ElevatorID, "msgID", msg.MsgID, "floor", be.Floor, "button", be.Button)
+	if msg.ElevatorID == n.ID && n.store.HasOrder(be, msg.ElevatorID) {
+		e := eventlog.Order(eventlog.OrderCompleted, msg.ElevatorID, be)
+		e.MsgID = msg.MsgID
+		eventlog.Record(e)
+	}
+	n.store.ClearOrder(be, msg.ElevatorID)
+	n.hallOrderDone(be)
+	n.orderCompleted(msg.ElevatorID, be)
+	n.triggerLamps()
+}
+
+func (n *Node) HandleButtonEvent(msg message.Message, pressed message.ButtonEvent) {
+	be := pressed.Event
+	n.orderPressed(msg.ElevatorID, be)
+
+	if be.Button == drivers.BT_Cab {
+		return
+	}
+	if len(n.store.ServedBy(be.Floor)) == 0 {
+		n.log().Warn("no elevator serves floor, ignoring hall call", "floor", be.Floor, "msgID", msg.MsgID)
+		return
+	}
+	// Every node keeps the board, for a new master to collect.
+	n.store.SetHallRequest(be)
+	delete(n.hallDoneAt, be)
+	if n.IsMaster {
+		n.delegateHallRequests(msg.MsgID)
+	}
+}
+
+func (n *Node) HandleCancelOrder(msg message.Message, cancel message.CancelOrder) {
+	be := cancel.Event
+	n.log().Info("order cancelled by operator", "from", msg.ElevatorID, "msgID", msg.MsgID, "floor", be.Floor, "button", be.Button, "elevator", cancel.TargetID)
+	if be.Button == drivers.BT_Cab {
+		if err := n.store.ClearOrder(be, cancel.TargetID); err != nil {
+			n.log().Warn("could not cancel cab order", "err", err, "msgID", msg.MsgID)
+			return
 		}
-		fmt.Println("-----------------------------------")
+		if cancel.TargetID == n.ID {
+			n.elevator.CancelOrder(be)
+		}
+	} else {
+		if err := n.store.CancelHallRequest(be); err != nil {
+			n.log().Warn("could not cancel hall order", "err", err, "msgID", msg.MsgID)
+			return
+		}
+		n.elevator.CancelOrder(be)
+		n.hallOrderDone(be)
 	}
+	delete(n.pendingOrders, orderKey(cancel.TargetID, be))
+	n.triggerLamps()
 }
 
-func MonitorSystemInputs(elevatorFSM *elevator.Elevator, msgTx chan message.Message) {
-	drvButtons := make(chan drivers.ButtonEvent)
-	drvFloors := make(chan int)
-	drvObstr := make(chan bool)
-	drvStop := make(chan bool)
+func (n *Node) HandleServiceMode(msg message.Message, mode message.ServiceMode) {
+	n.log().Info("service mode changed by operator", "from", msg.ElevatorID, "msgID", msg.MsgID, "elevator", mode.TargetID, "inService", mode.InService)
+	n.store.SetInService(mode.TargetID, mode.InService)
+	if mode.TargetID == n.ID {
+		n.elevator.SetInService(mode.InService)
+	}
+	if n.IsMaster {
+		// Move the hall orders off an elevator leaving service, which
+		// drops them when it gets the delegation, or give some to one
+		// coming back.
+		n.delegateHallRequests(msg.MsgID)
+	}
+}
 
-	go drivers.PollButtons(drvButtons)
-	go drivers.PollFloorSensor(drvFloors)
-	go drivers.PollObstructionSwitch(drvObstr)
-	go drivers.PollStopButton(drvStop)
+// delegateHallRequests runs the hall request assigner on the store and
+// broadcasts the resulting assignment. ackID is the message that caused it.
+// A master still collecting worldviews delegates when it is done instead.
+func (n *Node) delegateHallRequests(ackID int) {
+	if n.handover != nil {
+		n.log().Debug("handover in progress, delegating later", "msgID", ackID)
+		return
+	}
+	newOrder, err := HRA.HRARun(n.store)
+	if err != nil {
+		n.log().Error("hall request assigner failed", "err", err, "msgID", ackID)
+		return
+	}
+	orderMsg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.OrderDelegation{AckID: ackID, Orders: newOrder},
+	}
+	n.assignment = newOrder
+
+	for id, hallOrders := range newOrder {
+		elevatorID, _ := strconv.Atoi(id)
+		orderMsg.To = append(orderMsg.To, elevatorID)
+		for floor, dirs := range hallOrders {
+			for dir, assigned := range dirs {
+				if assigned {
+					e := eventlog.Order(eventlog.OrderDelegated, elevatorID, drivers.ButtonEvent{Floor: floor, Button: drivers.ButtonType(dir)})
+					e.MsgID = orderMsg.MsgID
+					eventlog.Record(e)
+				}
+			}
+		}
+	}
+
+	// The master confirms its hall lamps from its own delegation too.
+	if !slices.Contains(orderMsg.To, n.ID) {
+		orderMsg.To = append(orderMsg.To, n.ID)
+	}
+	slices.Sort(orderMsg.To)
+
+	n.MsgTx <- orderMsg
+}
+
+func (n *Node) StartWorldviewBC(ctx context.Context) {
+	ticker := n.clk.NewTicker(config.WorldviewBCInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case be := <-drvButtons:
-			//BC buttonevent on network
-			buttonEventMsg := message.Message{
-				Type:        message.ButtonEvent,
-				ElevatorID:  config.ElevatorID,
-				MsgID:       msgID.Next(),
-				ButtonEvent: be,
-			}
+		case <-ticker.C():
+			n.BroadcastWorldview()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			msgTx <- buttonEventMsg
+func (n *Node) MonitorSystemInputs(ctx context.Context, inputs Inputs) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
 
-			//If internal event(cab button) add order directly to request matrix
-			if be.Button == drivers.BT_Cab {
-				elevatorFSM.Orders <- be
-				drivers.SetButtonLamp(drivers.BT_Cab, be.Floor, true)
+		case be := <-inputs.Buttons:
+			if err := n.HandleButtonPress(be); err != nil {
+				n.log().Warn("ignoring button press", "err", err, "floor", be.Floor, "button", be.Button)
 			}
 
-		case <-drvFloors:
-			elevatorFSM.UpdateElevatorState(elevator.EventArrivedAtFloor)
+		case floor := <-inputs.Floors:
+			record.AddFloor(floor)
+			n.elevator.FloorReached(floor)
 
-		case obstr := <-drvObstr:
-			if obstr {
-				elevatorFSM.UpdateElevatorState(elevator.EventDoorObstructed)
-			} else {
-				elevatorFSM.UpdateElevatorState(elevator.EventDoorReleased)
-			}
+		case obstr := <-inputs.Obstruction:
+			n.HandleObstruction(obstr)
 
-		case <-drvStop:
+		case stop := <-inputs.Stop:
+			record.AddStop(stop)
 			//TODO: Implemnt stop logic
 		}
 	}
 }
 
+// HandleObstruction handles the obstruction switch being flipped.
+func (n *Node) HandleObstruction(obstructed bool) {
+	record.AddObstruction(obstructed)
+	if obstructed {
+		n.elevator.UpdateElevatorState(elevator.EventDoorObstructed)
+	} else {
+		n.elevator.UpdateElevatorState(elevator.EventDoorReleased)
+	}
+}
+
+// HandleButtonPress handles a button pressed on this node's panel, or through
+// the control API.
+func (n *Node) HandleButtonPress(be drivers.ButtonEvent) error {
+	record.AddButton(be)
+	if be.Floor < 0 || be.Floor >= config.NumFloors {
+		return fmt.Errorf("floor %d out of range", be.Floor)
+	}
+	if be.Button == drivers.BT_Cab && !n.elevator.ServesFloor(be.Floor) {
+		return fmt.Errorf("elevator does not serve floor %d", be.Floor)
+	}
+
+	//BC buttonevent on network
+	buttonEventMsg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.ButtonEvent{Event: be},
+	}
+
+	e := eventlog.Order(eventlog.ButtonPressed, n.ID, be)
+	e.MsgID = buttonEventMsg.MsgID
+	eventlog.Record(e)
+
+	n.MsgTx <- buttonEventMsg
+
+	//If internal event(cab button) add order directly to request matrix
+	if be.Button == drivers.BT_Cab {
+		n.elevator.Orders <- be
+		n.triggerLamps()
+	}
+	return nil
+}
+
 // TODO: Fix this function
-func StartMasterProcess(peerAddrs []string, elevatorFSM *elevator.Elevator, msgTx chan message.Message) {
-	ticker := time.NewTicker(2 * time.Second)
+func (n *Node) StartMasterProcess(peerAddrs []string) {
+	ticker := n.clk.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 	/*
-		for range ticker.C {
+		for range ticker.C() {
 			if elevatorFSM.ElevatorID == 1 { // Master elevator check
 				fmt.Println("[Master] Checking for unassigned orders...")
 
@@ -254,18 +580,56 @@ func StartMasterProcess(peerAddrs []string, elevatorFSM *elevator.Elevator, msgT
 	*/
 }
 
-func P2Pmonitor() {
+// Peers returns the last peer update: the peers on the network, including
+// this node, and their beacons.
+func (n *Node) Peers() peers.PeerUpdate {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	return n.peerUpdate
+}
+
+func (n *Node) P2Pmonitor(ctx context.Context, peerUpdateCh <-chan peers.PeerUpdate) {
 	//This function can be used to trigger events if units exit or enter the network
-	peerUpdateCh := make(chan peers.PeerUpdate)
-	peerTxEnable := make(chan bool)
-	go peers.Transmitter(config.P2Pport, strconv.Itoa(config.ElevatorID), peerTxEnable)
-	go peers.Receiver(config.P2Pport, peerUpdateCh)
 	for {
-		update := <-peerUpdateCh
-		Peers = update
-		fmt.Printf("Peer update:\n")
-		fmt.Printf("  Peers:    %q\n", update.Peers)
-		fmt.Printf("  New:      %q\n", update.New)
-		fmt.Printf("  Lost:     %q\n", update.Lost)
+		var update peers.PeerUpdate
+		select {
+		case update = <-peerUpdateCh:
+		case <-ctx.Done():
+			return
+		}
+		n.peersMu.Lock()
+		n.peerUpdate = update
+		n.peersMu.Unlock()
+		n.metrics.peerCount.Set(float64(len(update.Peers)))
+		n.negotiateProtocol(update.Beacons)
+		if n.book != nil {
+			for peer, addr := range update.Addrs {
+				if id, err := strconv.Atoi(peer); err == nil {
+					n.book.Learn(id, addr, update.Beacons[peer].Addrs)
+				}
+			}
+		}
+		if update.New != "" {
+			eventlog.Record(eventlog.Event{Kind: eventlog.PeerNew, Peer: update.New})
+		}
+		for _, lost := range update.Lost {
+			eventlog.Record(eventlog.Event{Kind: eventlog.PeerLost, Peer: lost})
+		}
+		for _, e := range update.Events {
+			switch e.Kind {
+			case failure.PeerSuspected:
+				eventlog.Record(eventlog.Event{Kind: eventlog.PeerSuspected, Peer: e.Peer})
+			case failure.PeerRecovered:
+				eventlog.Record(eventlog.Event{Kind: eventlog.PeerRecovered, Peer: e.Peer})
+			case failure.PeerRestarted:
+				eventlog.Record(eventlog.Event{Kind: eventlog.PeerRestarted, Peer: e.Peer})
+			}
+			select {
+			case n.liveness <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		n.log().Info("peer update", "peers", update.Peers, "new", update.New, "lost", update.Lost)
 	}
 }
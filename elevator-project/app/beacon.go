@@ -0,0 +1,59 @@
+package app
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/peers"
+	"strconv"
+)
+
+// The peer discovery beacons of a node announce its role, term, service
+// mode, unicast address and protocol versions, besides its ID. The term is
+// the cluster's, so of two nodes announcing themselves master the one with
+// the later term is. The message handler publishes a new beacon whenever one
+// of them changes.
+
+// Beacon returns what the beacons of this node should announce now.
+func (n *Node) Beacon() peers.Beacon {
+	b := peers.Beacon{
+		ID:          strconv.Itoa(n.ID),
+		Version:     config.Version,
+		Role:        peers.RoleSlave,
+		Term:        n.CurrentTerm,
+		Incarnation: n.incarnation,
+		InService:   true,
+		MinProtocol: message.MinProtocolVersion,
+		MaxProtocol: n.maxProtocol,
+	}
+	if n.IsMaster {
+		b.Role = peers.RoleMaster
+	}
+	if n.elevator != nil {
+		b.InService = n.elevator.GetStatus().InService
+	}
+	if n.unicastAddr != nil {
+		b.Addrs = []string{n.unicastAddr.String()}
+	}
+	return b
+}
+
+// BeaconUpdates returns the channel the beacons of this node are published on,
+// for the peers transmitter.
+func (n *Node) BeaconUpdates() <-chan peers.Beacon {
+	return n.beacons
+}
+
+// publishBeacon publishes the beacon of this node if it has changed. A beacon
+// the transmitter has not taken yet is replaced.
+func (n *Node) publishBeacon() {
+	b := n.Beacon()
+	if b.Equal(n.lastBeacon) {
+		return
+	}
+	n.lastBeacon = b
+	select {
+	case <-n.beacons:
+	default:
+	}
+	n.beacons <- b
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/dashboard"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/message"
+	"fmt"
+)
+
+// Operator carries out control API commands on this node. Commands that
+// affect other elevators are broadcast so every node applies them.
+type Operator struct {
+	n *Node
+}
+
+func NewOperator(n *Node) *Operator {
+	return &Operator{n: n}
+}
+
+func (op *Operator) PressButton(be drivers.ButtonEvent) error {
+	return op.n.HandleButtonPress(be)
+}
+
+func (op *Operator) CancelOrder(elevatorID int, be drivers.ButtonEvent) error {
+	if be.Floor < 0 || be.Floor >= config.NumFloors {
+		return fmt.Errorf("floor %d out of range", be.Floor)
+	}
+	if be.Button == drivers.BT_Cab {
+		if _, ok := op.n.store.GetAll()[elevatorID]; !ok {
+			return fmt.Errorf("unknown elevator %d", elevatorID)
+		}
+	}
+	eventlog.Record(eventlog.Order(eventlog.OrderCancelled, elevatorID, be))
+	op.n.MsgTx <- message.Message{
+		ElevatorID: op.n.ID,
+		MsgID:      op.n.msgID.Next(),
+		Payload:    message.CancelOrder{Event: be, TargetID: elevatorID},
+	}
+	return nil
+}
+
+func (op *Operator) SetInService(elevatorID int, inService bool) error {
+	if _, ok := op.n.store.GetAll()[elevatorID]; !ok {
+		return fmt.Errorf("unknown elevator %d", elevatorID)
+	}
+	eventlog.Record(eventlog.Event{Kind: eventlog.ServiceChanged, Elevator: elevatorID, Detail: fmt.Sprintf("inService=%t", inService)})
+	op.n.MsgTx <- message.Message{
+		ElevatorID: op.n.ID,
+		MsgID:      op.n.msgID.Next(),
+		Payload:    message.ServiceMode{TargetID: elevatorID, InService: inService},
+	}
+	return nil
+}
+
+func (op *Operator) HandOverMaster(elevatorID int) error {
+	if _, ok := op.n.store.GetAll()[elevatorID]; !ok {
+		return fmt.Errorf("unknown elevator %d", elevatorID)
+	}
+	_, term := op.n.Master()
+	op.n.MsgTx <- message.Message{
+		ElevatorID: op.n.ID,
+		MsgID:      op.n.msgID.Next(),
+		Payload:    message.MasterSlaveConfig{Master: elevatorID, Term: term + 1},
+	}
+	return nil
+}
+
+// Drain asks for this node to be drained, see Node.Drain.
+func (op *Operator) Drain() error {
+	op.n.RequestDrain()
+	return nil
+}
+
+func (op *Operator) State() dashboard.Snapshot {
+	return op.n.DashboardSnapshot()
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"elevator-project/pkg/dashboard"
+	"elevator-project/pkg/elevator"
+	"elevator-project/pkg/network/peers"
+	"sort"
+)
+
+// DashboardSnapshot returns this node's view of the cluster for the dashboard.
+func (n *Node) DashboardSnapshot() dashboard.Snapshot {
+	statuses := n.store.GetAll()
+	ids := make([]int, 0, len(statuses))
+	for id := range statuses {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	elevators := make([]dashboard.ElevatorView, 0, len(ids))
+	for _, id := range ids {
+		status := statuses[id]
+		elevators = append(elevators, dashboard.ElevatorView{
+			ID:              id,
+			State:           elevator.ElevatorState(status.State).String(),
+			Floor:           status.CurrentFloor,
+			Direction:       status.Direction,
+			TravelDirection: status.TravelDirection,
+			HallRequests:    status.RequestMatrix.HallRequests,
+			CabRequests:     status.RequestMatrix.CabRequests,
+			ServedFloors:    status.ServedFloors,
+			Draining:        n.store.Draining(id),
+			LastUpdated:     status.LastUpdated,
+		})
+	}
+
+	update := n.Peers()
+	masterID, term := n.Master()
+	beacons := make([]peers.Beacon, 0, len(update.Beacons))
+	for _, b := range update.Beacons {
+		beacons = append(beacons, b)
+	}
+	sort.Slice(beacons, func(i, j int) bool { return beacons[i].ID < beacons[j].ID })
+
+	return dashboard.Snapshot{
+		NodeID:                n.ID,
+		MasterID:              masterID,
+		IsMaster:              masterID == n.ID,
+		Term:                  term,
+		Peers:                 update.Peers,
+		Protocol:              n.Protocol(),
+		Suspected:             update.Suspected,
+		Beacons:               beacons,
+		HallRequests:          n.store.GetHallOrders(n.ID),
+		ConfirmedHallRequests: n.store.GetConfirmedHallRequests(),
+		Elevators:             elevators,
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/peers"
+	"strconv"
+)
+
+// A node is drained before it is restarted, e.g. on a new version, so that
+// no order is lost. It tells the master, which stops giving it new hall
+// orders but leaves it those it has, and serves them. Then it hands the
+// master role on if it has it, goes out of service and halts at the next
+// floor. Its cab calls are returned as by Shutdown, for the next start to
+// serve. Unlike Shutdown no hall order changes elevator.
+
+// RequestDrain asks for the node to be drained, for the process to do when
+// DrainRequested is closed.
+func (n *Node) RequestDrain() {
+	n.drainOnce.Do(func() { close(n.drainRequested) })
+}
+
+// DrainRequested is closed by RequestDrain.
+func (n *Node) DrainRequested() <-chan struct{} {
+	return n.drainRequested
+}
+
+// Drain drains the node and returns the cab calls left. The goroutines of the
+// node keep running until the context given to Start and Connect is done.
+func (n *Node) Drain(ctx context.Context) []bool {
+	n.log().Info("draining")
+	eventlog.Record(eventlog.Event{Kind: eventlog.NodeDraining, Elevator: n.ID})
+
+	if n.hasOtherPeers() {
+		n.announceDrain(ctx)
+	}
+	n.finishHallOrders(ctx)
+	n.handOnMaster(ctx)
+	n.elevator.SetInService(false)
+
+	n.elevator.Halt()
+	select {
+	case <-n.elevator.Halted():
+	case <-ctx.Done():
+		n.log().Warn("drain timed out before the elevator halted")
+	}
+	n.log().Info("drained")
+	return n.elevator.CabRequests()
+}
+
+// announceDrain announces the drain until the master has answered.
+func (n *Node) announceDrain(ctx context.Context) {
+	for {
+		n.announceDeparture(ctx, message.Drain{})
+		select {
+		case <-n.handedOff:
+			n.log().Info("master knows of the drain")
+			return
+		case <-n.clk.After(departureRetry):
+		case <-ctx.Done():
+			n.log().Warn("drain timed out before the master answered")
+			return
+		}
+	}
+}
+
+// handOnMaster hands the master role to the peer in service with the lowest
+// ID, if this node is master, until the beacons of that peer announce it in
+// the new term.
+func (n *Node) handOnMaster(ctx context.Context) {
+	master, term := n.Master()
+	if master != n.ID {
+		return
+	}
+	for {
+		id, ok := n.successor()
+		if !ok {
+			n.log().Warn("no peer in service to hand the master role on to")
+			return
+		}
+		n.log().Info("handing the master role on", "master", id)
+		select {
+		case n.MsgTx <- message.Message{
+			ElevatorID: n.ID,
+			MsgID:      n.msgID.Next(),
+			Payload:    message.MasterSlaveConfig{Master: id, Term: term + 1},
+		}:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-n.clk.After(departureRetry):
+		case <-ctx.Done():
+			n.log().Warn("drain timed out before the master role was taken")
+			return
+		}
+		if b := n.Peers().Beacons[strconv.Itoa(id)]; b.Role == peers.RoleMaster && b.Term > term {
+			n.log().Info("master role taken", "master", id)
+			return
+		}
+	}
+}
+
+// successor returns the peer in service with the lowest ID, other than this
+// node.
+func (n *Node) successor() (int, bool) {
+	update := n.Peers()
+	successor := 0
+	for _, peer := range update.Peers {
+		id, err := strconv.Atoi(peer)
+		if err != nil || id == n.ID || !update.Beacons[peer].InService {
+			continue
+		}
+		if successor == 0 || id < successor {
+			successor = id
+		}
+	}
+	return successor, successor != 0
+}
+
+// HandleDrain stops giving new hall orders to a draining elevator. The master
+// reassigns the hall orders, which answers the drain.
+func (n *Node) HandleDrain(msg message.Message, _ message.Drain) {
+	n.log().Info("node draining", "elevator", msg.ElevatorID, "msgID", msg.MsgID)
+	n.store.SetDraining(msg.ElevatorID, true)
+	if n.IsMaster {
+		n.delegateHallRequests(msg.MsgID)
+	}
+}
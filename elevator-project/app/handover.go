@@ -0,0 +1,183 @@
+package app
+
+import (
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/record"
+	"elevator-project/pkg/state"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// handoverRetry is how often a new master asks the nodes that have not sent
+// their worldview yet.
+const handoverRetry = 250 * time.Millisecond
+
+// handover is a new master collecting the worldviews of the live nodes. Until
+// it is done the master takes hall calls but does not delegate them, so an
+// assignment never leaves out orders the master has not heard about.
+type handover struct {
+	promotions map[int]bool // MsgIDs of the Promotions sent
+	replied    map[int]bool // Elevators whose worldview has been merged
+	started    time.Time
+	retry      clock.Timer
+}
+
+// beginHandover starts collecting worldviews. It is called when this node
+// becomes master.
+func (n *Node) beginHandover() {
+	if n.handover != nil {
+		n.handover.retry.Stop()
+	}
+	n.handover = &handover{
+		promotions: make(map[int]bool),
+		replied:    make(map[int]bool),
+		started:    n.clk.Now(),
+	}
+	n.log().Info("collecting worldviews before delegating", "timeout", config.HandoverTimeout)
+	n.promote()
+}
+
+// promote asks every node for its worldview, and sets the retry timer.
+func (n *Node) promote() {
+	msg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.Promotion{},
+	}
+	n.handover.promotions[msg.MsgID] = true
+	n.handover.retry = n.clk.NewTimer(handoverRetry)
+	n.MsgTx <- msg
+}
+
+// handoverTimer returns the retry timer of a handover in progress, or nil.
+func (n *Node) handoverTimer() <-chan time.Time {
+	if n.handover == nil {
+		return nil
+	}
+	return n.handover.retry.C()
+}
+
+// HandoverTimerElapsed finishes the handover when every live node has sent
+// its worldview or the handover has timed out, and asks again otherwise.
+func (n *Node) HandoverTimerElapsed() {
+	record.AddTimer(record.HandoverTimer)
+	if n.handover == nil {
+		return
+	}
+	if n.clk.Since(n.handover.started) >= config.HandoverTimeout {
+		n.log().Warn("handover timed out", "missing", n.missingWorldviews())
+		n.finishHandover()
+		return
+	}
+	if len(n.missingWorldviews()) == 0 {
+		n.finishHandover()
+		return
+	}
+	n.promote()
+}
+
+// missingWorldviews returns the live peers whose worldview has not been
+// merged.
+func (n *Node) missingWorldviews() []int {
+	missing := []int{}
+	for _, peer := range n.Peers().Peers {
+		id, err := strconv.Atoi(peer)
+		if err != nil || id == n.ID || n.handover.replied[id] {
+			continue
+		}
+		missing = append(missing, id)
+	}
+	return missing
+}
+
+// HandlePromotion answers a Promotion from another node with the status of the
+// local elevator and the hall request board of this node.
+func (n *Node) HandlePromotion(msg message.Message, _ message.Promotion) {
+	if msg.ElevatorID == n.ID {
+		return
+	}
+	n.log().Info("sending worldview to new master", "master", msg.ElevatorID, "msgID", msg.MsgID)
+	n.MsgTx <- message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload: message.Worldview{
+			AckID:        msg.MsgID,
+			Status:       *stateData(n.elevator.GetStatus()),
+			HallRequests: n.store.GetHallOrders(n.ID),
+		},
+		To: []int{msg.ElevatorID},
+	}
+}
+
+// HandleWorldview stores the status in a Worldview, and merges its hall
+// requests if it answers a Promotion of the handover in progress.
+func (n *Node) HandleWorldview(msg message.Message, worldview message.Worldview) {
+	n.store.UpdateStatus(statusOf(&worldview.Status))
+	if n.handover == nil || !n.handover.promotions[worldview.AckID] || msg.ElevatorID == n.ID {
+		return
+	}
+	n.store.MergeHallRequests(worldview.HallRequests)
+	n.store.MergeHallRequests(worldview.Status.RequestMatrix.HallRequests)
+	n.handover.replied[msg.ElevatorID] = true
+	n.log().Debug("merged worldview", "from", msg.ElevatorID, "msgID", msg.MsgID)
+	if len(n.missingWorldviews()) == 0 {
+		n.finishHandover()
+	}
+}
+
+// finishHandover merges the local elevator's hall orders and delegates the
+// merged board.
+func (n *Node) finishHandover() {
+	h := n.handover
+	h.retry.Stop()
+	n.handover = nil
+	n.store.MergeHallRequests(n.elevator.HallRequests())
+
+	n.log().Info("handover done", "worldviews", len(h.replied), "took", n.clk.Since(h.started))
+	eventlog.Record(eventlog.Event{Kind: eventlog.HandoverDone, Master: n.ID, Detail: fmt.Sprintf("worldviews=%d", len(h.replied))})
+	var promotion int
+	for id := range h.promotions {
+		promotion = max(promotion, id)
+	}
+	n.delegateHallRequests(promotion)
+}
+
+// endHandover abandons a handover in progress, when this node is no longer
+// master.
+func (n *Node) endHandover() {
+	if n.handover != nil {
+		n.handover.retry.Stop()
+		n.handover = nil
+	}
+}
+
+func stateData(status state.ElevatorStatus) *message.ElevatorState {
+	return &message.ElevatorState{
+		ElevatorID:      status.ElevatorID,
+		State:           status.State,
+		CurrentFloor:    status.CurrentFloor,
+		TravelDirection: status.TravelDirection,
+		LastUpdated:     status.LastUpdated,
+		RequestMatrix:   status.RequestMatrix,
+		ServedFloors:    status.ServedFloors,
+		InService:       status.InService,
+	}
+}
+
+func statusOf(data *message.ElevatorState) state.ElevatorStatus {
+	return state.ElevatorStatus{
+		ElevatorID:      data.ElevatorID,
+		State:           data.State,
+		Direction:       data.Direction,
+		CurrentFloor:    data.CurrentFloor,
+		TravelDirection: data.TravelDirection,
+		RequestMatrix:   data.RequestMatrix,
+		LastUpdated:     data.LastUpdated,
+		ServedFloors:    data.ServedFloors,
+		InService:       data.InService,
+	}
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"elevator-project/pkg/failure"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/record"
+	"slices"
+	"strconv"
+)
+
+// The failure detector in peers decides which nodes are alive, from their
+// beacons. Its events reach the message handler through P2Pmonitor. An
+// elevator that is dead gets no hall orders until it recovers, and the master
+// reassigns the hall orders when one dies or recovers.
+//
+// A node that restarts announces a new incarnation in its beacons, even if it
+// was back before anyone found it dead. It has lost its hall orders, which
+// the master reassigns, and its cab calls, which the master sends back from
+// the last worldview of the old incarnation. It starts out with node 1 as
+// master, so the master, if another node, announces itself to it.
+//
+// When the master is dead, the live node in service with the lowest ID
+// becomes master in the next term and announces itself. A master that finds
+// a dead peer alive again collects the worldviews anew before it delegates,
+// as a partition may have had a master of its own.
+
+// HandleLiveness handles a failure detector event about a peer.
+func (n *Node) HandleLiveness(e failure.Event) {
+	record.AddLiveness(e)
+	id, err := strconv.Atoi(e.Peer)
+	if err != nil || id == n.ID {
+		return
+	}
+	switch e.Kind {
+	case failure.PeerSuspected:
+		n.log().Info("peer suspected", "elevator", id, "phi", e.Phi, "master", id == n.CurrentMasterID)
+		return
+	case failure.PeerDead:
+		n.log().Warn("peer dead", "elevator", id, "phi", e.Phi, "master", id == n.CurrentMasterID)
+		n.store.SetReachable(id, false)
+		n.failover()
+	case failure.PeerRecovered:
+		if n.store.Reachable(id) {
+			n.log().Info("peer no longer suspected", "elevator", id)
+			return
+		}
+		n.log().Info("peer recovered", "elevator", id)
+		n.store.SetReachable(id, true)
+		if n.IsMaster {
+			// It may have been in another partition, with hall calls
+			// this node has not heard of.
+			n.beginHandover()
+			return
+		}
+	case failure.PeerRestarted:
+		n.log().Warn("peer restarted", "elevator", id, "master", id == n.CurrentMasterID)
+		delete(n.worldviewVersions, id)
+		delete(n.keyframeRequests, id)
+		n.store.SetDraining(id, false)
+		n.store.ForgetServiceMode(id)
+		n.resendCabCalls(id)
+		n.announceMaster(id)
+	}
+	if n.IsMaster {
+		n.delegateHallRequests(0)
+	}
+}
+
+// failover makes this node master if the master is dead and this node is the
+// live node in service with the lowest ID. The others wait for its
+// MasterSlaveConfig.
+func (n *Node) failover() {
+	if n.IsMaster || n.store.Reachable(n.CurrentMasterID) {
+		return
+	}
+	if next, ok := n.nextMaster(); !ok || next != n.ID {
+		return
+	}
+	term := n.CurrentTerm + 1
+	n.log().Warn("master dead, taking over", "master", n.CurrentMasterID, "newTerm", term)
+	n.setMaster(n.ID, term)
+	n.MsgTx <- message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.MasterSlaveConfig{Master: n.ID, Term: term},
+	}
+}
+
+// nextMaster returns the lowest ID of the live nodes in service, this one
+// included.
+func (n *Node) nextMaster() (int, bool) {
+	next := 0
+	if n.elevator == nil || n.elevator.GetStatus().InService {
+		next = n.ID
+	}
+	update := n.Peers()
+	for _, peer := range update.Peers {
+		id, err := strconv.Atoi(peer)
+		if err != nil || !n.store.Reachable(id) || !update.Beacons[peer].InService {
+			continue
+		}
+		if next == 0 || id < next {
+			next = id
+		}
+	}
+	return next, next != 0
+}
+
+// resendCabCalls sends restarted elevator id the cab calls it last had. The
+// master sends them, or every other node if the master is the one that
+// restarted; restoring a cab call twice does no harm.
+func (n *Node) resendCabCalls(id int) {
+	if !n.IsMaster && id != n.CurrentMasterID {
+		return
+	}
+	cab := n.store.GetAll()[id].RequestMatrix.CabRequests
+	if !slices.Contains(cab, true) {
+		return
+	}
+	n.log().Info("sending cab calls back to restarted elevator", "elevator", id, "cabCalls", cab)
+	n.MsgTx <- message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.CabCallRestore{TargetID: id, CabCalls: slices.Clone(cab)},
+		To:         []int{id},
+	}
+}
+
+// announceMaster tells restarted elevator id that this node is master, if it
+// is.
+func (n *Node) announceMaster(id int) {
+	if !n.IsMaster {
+		return
+	}
+	n.MsgTx <- message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.MasterSlaveConfig{Master: n.ID, Term: n.CurrentTerm},
+	}
+}
+
+// HandleCabCallRestore takes the cab calls this elevator had before it
+// restarted that it does not have already.
+func (n *Node) HandleCabCallRestore(msg message.Message, restore message.CabCallRestore) {
+	if restore.TargetID != n.ID {
+		return
+	}
+	have := n.elevator.CabRequests()
+	missing := make([]bool, len(restore.CabCalls))
+	for floor, active := range restore.CabCalls {
+		missing[floor] = active && (floor >= len(have) || !have[floor])
+	}
+	n.log().Info("restoring cab calls from before the restart", "from", msg.ElevatorID, "msgID", msg.MsgID, "cabCalls", missing)
+	n.RestoreCabCalls(missing)
+}
@@ -1,93 +1,78 @@
 package app
 
 import (
-	"elevator-project/pkg/config"
+	"elevator-project/pkg/eventlog"
 	"elevator-project/pkg/message"
-	"elevator-project/pkg/state"
 	"fmt"
-	"time"
+	"log/slog"
 )
 
-// Handle master/slave configuration messages
-func HandleMasterSlaveMessage(msg message.Message) {
-	fmt.Printf("Received master config update: new master is elevator %d\n", msg.ElevatorID)
-	CurrentMasterID = msg.ElevatorID
-	IsMaster = (config.ElevatorID == msg.ElevatorID)
-}
-
-// Monitor master heartbeat and elect a new master if necessary
-func MonitorMasterHeartbeat(peerAddrs []string) {
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		statuses := masterStateStore.GetAll()
-		masterStatus, exists := statuses[CurrentMasterID]
-
-		if !exists || time.Since(masterStatus.LastUpdated) > 5*time.Second {
-			candidate := config.ElevatorID
-			for id, status := range statuses {
-				if id != CurrentMasterID && time.Since(status.LastUpdated) <= 5*time.Second && id < candidate {
-					candidate = id
-				}
-			}
-			if config.ElevatorID == candidate {
-				PromoteToMaster(peerAddrs)
-				break
-			}
-		}
+// setMaster records master id for term. A node becoming master collects the
+// worldviews of the others before it delegates.
+func (n *Node) setMaster(id, term int) {
+	wasMaster := n.IsMaster
+	n.masterMu.Lock()
+	changed := id != n.CurrentMasterID || term != n.CurrentTerm
+	n.CurrentMasterID = id
+	n.CurrentTerm = term
+	n.logger = n.loggerFor(term)
+	n.IsMaster = (n.ID == id)
+	n.masterMu.Unlock()
+	if changed {
+		eventlog.Record(eventlog.Event{Kind: eventlog.MasterChanged, Master: id, Detail: fmt.Sprintf("term %d", n.CurrentTerm)})
+	}
+	n.metrics.currentMaster.Set(float64(id))
+	if n.IsMaster && !wasMaster {
+		n.beginHandover()
+	} else if !n.IsMaster {
+		n.endHandover()
 	}
 }
 
-// Promote this elevator to master
-func PromoteToMaster(peerAddrs []string) {
-	IsMaster = true
-	/*CurrentMasterID = LocalElevatorID
-	fmt.Printf("Elevator %d is now promoted to master.\n", LocalElevatorID)
+// Master returns the current master and term. Unlike the fields, it is safe
+// to call from goroutines other than MessageHandler.
+func (n *Node) Master() (id int, term int) {
+	n.masterMu.Lock()
+	defer n.masterMu.Unlock()
+	return n.CurrentMasterID, n.CurrentTerm
+}
 
-	configMsg := message.Message{
-		Type:       message.MasterSlaveConfig,
-		ElevatorID: LocalElevatorID,
-		Seq:        0,
+// newerClaim reports whether master id in term replaces the current master.
+// A later term does. Two nodes may start the same term at once, and then the
+// lower ID wins.
+func (n *Node) newerClaim(id, term int) bool {
+	if term != n.CurrentTerm {
+		return term > n.CurrentTerm
 	}
-
-	for _, addr := range peerAddrs {
-		if err := transport.SendMessage(configMsg, addr); err != nil {
-			fmt.Printf("Error broadcasting master config to %s: %v\n", addr, err)
-		}
-	}
-	*/
+	return id <= n.CurrentMasterID
 }
 
-// Monitor elevator heartbeats and reassign orders if necessary
-func MonitorElevatorHeartbeats() {
-	if !IsMaster {
-		return
-	}
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+// log returns the logger of this node, which attaches its ID and term to
+// every line so the logs of the nodes of a simulation can be told apart.
+func (n *Node) log() *slog.Logger {
+	n.masterMu.Lock()
+	defer n.masterMu.Unlock()
+	return n.logger
+}
 
-	for range ticker.C {
-		statuses := masterStateStore.GetAll()
-		for id, status := range statuses {
-			if id == config.ElevatorID {
-				continue
-			}
-			if time.Since(status.LastUpdated) > 5*time.Second {
-				fmt.Printf("Elevator %d heartbeat stale. Reassigning its orders.\n", id)
-				ReassignOrders(status)
-			}
-		}
-	}
+func (n *Node) loggerFor(term int) *slog.Logger {
+	return log.With("node", n.ID, "term", term)
 }
 
-// Reassign orders from a failed elevator to active elevators
-func ReassignOrders(failedStatus state.ElevatorStatus) {
-	for floor, hallRequests := range failedStatus.RequestMatrix.HallRequests {
-		for dir, active := range hallRequests {
-			if active {
-				fmt.Printf("Reassigning hall request at floor %d, direction %d from failed elevator %d.\n", floor, dir, failedStatus.ElevatorID)
-			}
+// Handle master/slave configuration messages
+func (n *Node) HandleMasterSlaveConfig(msg message.Message, cfg message.MasterSlaveConfig) {
+	n.log().Info("received master config update", "from", msg.ElevatorID, "master", cfg.Master, "masterTerm", cfg.Term, "msgID", msg.MsgID)
+	term := cfg.Term
+	if term == 0 {
+		// Nodes that do not count terms start the next one with a new master.
+		term = n.CurrentTerm
+		if cfg.Master != n.CurrentMasterID {
+			term++
 		}
 	}
+	if !n.newerClaim(cfg.Master, term) {
+		n.log().Info("ignoring master config from an older term", "master", cfg.Master, "masterTerm", term)
+		return
+	}
+	n.setMaster(cfg.Master, term)
 }
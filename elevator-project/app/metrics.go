@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/metrics"
+	"elevator-project/pkg/network/unicast"
+	"elevator-project/pkg/sync"
+	"fmt"
+	"log/slog"
+)
+
+// Metrics are the metrics of a node, in a registry of its own.
+type Metrics struct {
+	Registry         *metrics.Registry
+	messagesSent     *metrics.CounterVec
+	messagesReceived *metrics.CounterVec
+	duplicatePackets *metrics.Counter
+	droppedPackets   *metrics.Counter
+	orderWaitSeconds *metrics.Histogram
+	peerCount        *metrics.Gauge
+	currentMaster    *metrics.Gauge
+}
+
+// NewMetrics creates the metrics of a node in a new registry.
+func NewMetrics() *Metrics {
+	r := metrics.NewRegistry()
+	return &Metrics{
+		Registry:         r,
+		messagesSent:     r.NewCounterVec("elevator_messages_sent_total", "Messages sent, by message type.", "type"),
+		messagesReceived: r.NewCounterVec("elevator_messages_received_total", "Messages received, by message type.", "type"),
+		duplicatePackets: r.NewCounter("elevator_duplicate_packets_total", "Received messages whose MsgID had already been seen from that sender."),
+		droppedPackets:   r.NewCounter("elevator_dropped_packets_total", "Gaps in the MsgID sequence of received messages."),
+		orderWaitSeconds: r.NewHistogram("elevator_order_wait_seconds", "Time from a button press to the order being completed.", metrics.DefaultBuckets),
+		peerCount:        r.NewGauge("elevator_peers", "Number of peers currently seen on the network, including this node."),
+		currentMaster:    r.NewGauge("elevator_master_id", "Elevator ID of the current master."),
+	}
+}
+
+// ForwardOutgoing passes messages from msgTx on to the network transmitter and
+// counts them in m, until ctx is done. Directed messages go to uniTx instead,
+// if it is not nil. The messages are sent in the protocol version protocol
+// returns.
+func ForwardOutgoing(ctx context.Context, msgTx <-chan message.Message, netTx chan<- message.Message, uniTx chan<- unicast.Packet, protocol func() int, m *Metrics) {
+	for {
+		select {
+		case msg := <-msgTx:
+			msg.Protocol = protocol()
+			m.messagesSent.Inc(msg.Type().String())
+			if len(msg.To) > 0 && uniTx != nil {
+				select {
+				case uniTx <- unicast.Packet{To: msg.To, Msg: msg}:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case netTx <- msg:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ForwardIncoming passes messages from the network receiver on to msgRx,
+// counting them in m and detecting duplicates and gaps in each sender's MsgID
+// sequence. Messages in a protocol version above maxProtocol, or one that
+// could not be decoded, are rejected, see message.Rejector. Gaps are logged
+// to the logger that logger returns. It returns when ctx is done.
+func ForwardIncoming(ctx context.Context, netRx <-chan message.Message, msgRx chan<- message.Message, maxProtocol int, m *Metrics, logger func() *slog.Logger) {
+	tracker := sync.NewTracker()
+	rejector := message.NewRejector()
+	for {
+		var msg message.Message
+		select {
+		case msg = <-netRx:
+		case <-ctx.Done():
+			return
+		}
+		if msg.Protocol > maxProtocol || !message.Speaks(msg.Protocol) {
+			rejector.Reject(&message.DecodeError{
+				ElevatorID: msg.ElevatorID,
+				Protocol:   msg.Protocol,
+				Reason:     message.ReasonProtocol,
+				Err:        fmt.Errorf("protocol version %d not spoken, max %d", msg.Protocol, maxProtocol),
+			})
+			continue
+		}
+		m.messagesReceived.Inc(msg.Type().String())
+		duplicate, missed := tracker.Observe(msg.ElevatorID, msg.MsgID)
+		if duplicate {
+			m.duplicatePackets.Inc()
+		}
+		if missed > 0 {
+			m.droppedPackets.Add(float64(missed))
+			logger().Debug("gap in message sequence", "from", msg.ElevatorID, "msgID", msg.MsgID, "missed", missed)
+		}
+		select {
+		case msgRx <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func orderKey(elevatorID int, be drivers.ButtonEvent) string {
+	if be.Button == drivers.BT_Cab {
+		return fmt.Sprintf("cab-%d-%d", elevatorID, be.Floor)
+	}
+	return fmt.Sprintf("hall-%d-%d", be.Floor, be.Button)
+}
+
+func (n *Node) orderPressed(elevatorID int, be drivers.ButtonEvent) {
+	key := orderKey(elevatorID, be)
+	if _, pending := n.pendingOrders[key]; !pending {
+		n.pendingOrders[key] = n.clk.Now()
+	}
+}
+
+func (n *Node) orderCompleted(elevatorID int, be drivers.ButtonEvent) {
+	key := orderKey(elevatorID, be)
+	if pressed, pending := n.pendingOrders[key]; pending {
+		n.metrics.orderWaitSeconds.Observe(n.clk.Since(pressed).Seconds())
+		delete(n.pendingOrders, key)
+	}
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/peers"
+)
+
+// A node sends its messages in the highest protocol version that it and every
+// live peer speak, from the versions announced in their beacons. Upgraded
+// nodes keep speaking the old version until the last old node has left, so a
+// cluster can be upgraded one node at a time. A node starts out in the oldest
+// version, until it has heard the beacons. config.ProtocolVersion pins a node
+// to an older version, making it behave like the nodes of that version.
+// Messages in a version a node does not speak are dropped by ForwardIncoming.
+
+// highestProtocol returns the highest protocol version a new node speaks.
+func highestProtocol() int {
+	if config.ProtocolVersion > 0 {
+		return min(config.ProtocolVersion, message.ProtocolVersion)
+	}
+	return message.ProtocolVersion
+}
+
+// Protocol returns the protocol version this node sends in.
+func (n *Node) Protocol() int {
+	return int(n.protocol.Load())
+}
+
+// negotiateProtocol sends in the highest protocol version this node and every
+// peer in beacons speak. Peers without a version in common are left out,
+// their messages and those of this node are dropped on both sides. Only
+// called from P2Pmonitor.
+func (n *Node) negotiateProtocol(beacons map[string]peers.Beacon) {
+	version := n.maxProtocol
+	for peer, b := range beacons {
+		lo, hi := b.Protocols()
+		if hi < message.MinProtocolVersion || lo > n.maxProtocol {
+			if !n.incompatiblePeers[peer] {
+				n.log().Error("peer speaks no protocol version in common", "peer", peer, "min", lo, "max", hi, "software", b.Version)
+				n.incompatiblePeers[peer] = true
+			}
+			continue
+		}
+		delete(n.incompatiblePeers, peer)
+		version = min(version, hi)
+	}
+	if old := n.protocol.Swap(int64(version)); int(old) != version {
+		n.log().Info("protocol version changed", "from", old, "to", version)
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/message"
+	"strconv"
+	"time"
+)
+
+// departureRetry is how long Shutdown waits for the master to answer a
+// Departure before sending another.
+const departureRetry = 500 * time.Millisecond
+
+// Shutdown leaves the cluster gracefully. The elevator goes out of service,
+// its hall orders are handed off to the other elevators through the master,
+// or served first when there are no other nodes, and the car halts at the
+// next floor. It returns the cab calls left, for the next start of the node
+// to serve. The goroutines of the node keep running until the context given
+// to Start and Connect is done.
+func (n *Node) Shutdown(ctx context.Context) []bool {
+	n.log().Info("shutting down")
+	eventlog.Record(eventlog.Event{Kind: eventlog.NodeLeaving, Elevator: n.ID})
+	n.elevator.SetInService(false)
+
+	if n.hasOtherPeers() {
+		n.handOffHallOrders(ctx)
+	} else {
+		n.finishHallOrders(ctx)
+	}
+
+	n.elevator.Halt()
+	select {
+	case <-n.elevator.Halted():
+	case <-ctx.Done():
+		n.log().Warn("shutdown timed out before the elevator halted")
+	}
+	return n.elevator.CabRequests()
+}
+
+// RestoreCabCalls takes the cab calls returned by Shutdown as if they had just
+// been pressed. The node must have been started.
+func (n *Node) RestoreCabCalls(cab []bool) {
+	for floor, active := range cab {
+		if !active {
+			continue
+		}
+		if err := n.HandleButtonPress(drivers.ButtonEvent{Floor: floor, Button: drivers.BT_Cab}); err != nil {
+			n.log().Warn("could not restore cab call", "err", err, "floor", floor)
+		}
+	}
+}
+
+// handOffHallOrders announces the departure until the master has reassigned
+// the hall orders, and then drops them from the elevator.
+func (n *Node) handOffHallOrders(ctx context.Context) {
+	for {
+		n.announceDeparture(ctx, message.Departure{})
+		select {
+		case <-n.handedOff:
+			for floor, dirs := range n.elevator.HallRequests() {
+				for dir, active := range dirs {
+					if active {
+						n.elevator.CancelOrder(drivers.ButtonEvent{Floor: floor, Button: drivers.ButtonType(dir)})
+					}
+				}
+			}
+			n.log().Info("hall orders handed off")
+			return
+		case <-n.clk.After(departureRetry):
+		case <-ctx.Done():
+			n.log().Warn("shutdown timed out before the master took over the hall orders")
+			return
+		}
+	}
+}
+
+// finishHallOrders waits for the elevator to serve its hall orders.
+func (n *Node) finishHallOrders(ctx context.Context) {
+	for {
+		left := false
+		for _, dirs := range n.elevator.HallRequests() {
+			left = left || dirs[0] || dirs[1]
+		}
+		if !left {
+			return
+		}
+		select {
+		case <-n.clk.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			n.log().Warn("shutdown timed out before the hall orders were served")
+			return
+		}
+	}
+}
+
+// announceDeparture sends a Departure or Drain, p, for the master to answer.
+func (n *Node) announceDeparture(ctx context.Context, p message.Payload) {
+	msg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    p,
+	}
+	n.departuresMu.Lock()
+	n.departures[msg.MsgID] = true
+	n.departuresMu.Unlock()
+	select {
+	case n.MsgTx <- msg:
+	case <-ctx.Done():
+	}
+}
+
+func (n *Node) isDeparture(msgID int) bool {
+	n.departuresMu.Lock()
+	defer n.departuresMu.Unlock()
+	return n.departures[msgID]
+}
+
+func (n *Node) hasOtherPeers() bool {
+	for _, peer := range n.Peers().Peers {
+		if peer != strconv.Itoa(n.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleDeparture takes a node that is shutting down out of service. The
+// master reassigns its hall orders, which answers the departure.
+func (n *Node) HandleDeparture(msg message.Message, _ message.Departure) {
+	n.log().Info("node leaving", "elevator", msg.ElevatorID, "msgID", msg.MsgID)
+	n.store.SetInService(msg.ElevatorID, false)
+	if n.IsMaster {
+		n.delegateHallRequests(msg.MsgID)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/record"
+	"strconv"
+)
+
+// The master broadcasts a snapshot of its hall request board, the current
+// assignment and the hall lamps it implies every SnapshotInterval, and applies
+// it itself. A node that has missed a delegation or a completion takes the
+// board and lamps from it, and the hall orders assigned to its own elevator.
+// Hall orders that the node has seen completed during the last interval are
+// left out, as the snapshot may have been sent before the master heard of the
+// completion.
+
+// SnapshotTimerElapsed broadcasts a snapshot if this node is master and not
+// collecting worldviews.
+func (n *Node) SnapshotTimerElapsed() {
+	if !n.IsMaster || n.handover != nil {
+		return
+	}
+	record.AddTimer(record.SnapshotTimer)
+	board := n.store.GetHallOrders(n.ID)
+	lamps := make([][2]bool, len(board))
+	assignment := make(map[string][][2]bool, len(n.assignment))
+	for id, hallOrders := range n.assignment {
+		assigned := make([][2]bool, len(hallOrders))
+		for floor := 0; floor < len(hallOrders) && floor < len(board); floor++ {
+			for dir := 0; dir < 2; dir++ {
+				assigned[floor][dir] = hallOrders[floor][dir] && board[floor][dir]
+				lamps[floor][dir] = lamps[floor][dir] || assigned[floor][dir]
+			}
+		}
+		assignment[id] = assigned
+	}
+	snapshot := message.Snapshot{
+		Term:         n.CurrentTerm,
+		HallRequests: board,
+		Assignment:   assignment,
+		HallLamps:    lamps,
+	}
+	msg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    snapshot,
+	}
+	n.applySnapshot(msg, snapshot)
+	n.MsgTx <- msg
+}
+
+// HandleSnapshot applies a snapshot from the master. The master has applied
+// its own when sending it. A snapshot from a later term means this node has
+// missed the MasterSlaveConfig, and its sender is taken as master.
+func (n *Node) HandleSnapshot(msg message.Message, snapshot message.Snapshot) {
+	if msg.ElevatorID == n.ID {
+		return
+	}
+	if snapshot.Term < n.CurrentTerm {
+		n.log().Debug("ignoring snapshot from an older term", "from", msg.ElevatorID, "snapshotTerm", snapshot.Term, "msgID", msg.MsgID)
+		return
+	}
+	if snapshot.Term > n.CurrentTerm {
+		// The board of the new master may not have the hall calls of this
+		// node yet, so it waits for the next snapshot.
+		n.log().Info("snapshot from a later term, taking its sender as master", "from", msg.ElevatorID, "snapshotTerm", snapshot.Term, "msgID", msg.MsgID)
+		n.setMaster(msg.ElevatorID, snapshot.Term)
+		return
+	}
+	if msg.ElevatorID != n.CurrentMasterID {
+		n.log().Debug("ignoring snapshot from a node that is not master", "from", msg.ElevatorID, "master", n.CurrentMasterID, "msgID", msg.MsgID)
+		return
+	}
+	n.applySnapshot(msg, snapshot)
+}
+
+func (n *Node) applySnapshot(msg message.Message, snapshot message.Snapshot) {
+	board := n.withoutRecentlyCompleted(snapshot.HallRequests)
+	lamps := n.withoutRecentlyCompleted(snapshot.HallLamps)
+	n.store.SetHallBoard(board, lamps)
+
+	assigned := n.withoutRecentlyCompleted(snapshot.Assignment[strconv.Itoa(n.ID)])
+	have := n.elevator.HallRequests()
+	for floor := 0; floor < len(assigned) && floor < len(have); floor++ {
+		for dir := 0; dir < 2; dir++ {
+			if assigned[floor][dir] && !have[floor][dir] {
+				n.log().Info("taking hall order missed before", "floor", floor, "dir", dir, "msgID", msg.MsgID)
+				n.takeOrder(drivers.ButtonEvent{Floor: floor, Button: drivers.ButtonType(dir)})
+			}
+		}
+	}
+	n.triggerLamps()
+}
+
+// hallOrderDone records when a hall order was completed or cancelled.
+func (n *Node) hallOrderDone(be drivers.ButtonEvent) {
+	if be.Button != drivers.BT_Cab {
+		n.hallDoneAt[be] = n.clk.Now()
+	}
+}
+
+// withoutRecentlyCompleted returns a copy of hall without the hall orders
+// completed or cancelled during the last SnapshotInterval.
+func (n *Node) withoutRecentlyCompleted(hall [][2]bool) [][2]bool {
+	filtered := make([][2]bool, len(hall))
+	for floor := range hall {
+		for dir := 0; dir < 2; dir++ {
+			done, ok := n.hallDoneAt[drivers.ButtonEvent{Floor: floor, Button: drivers.ButtonType(dir)}]
+			filtered[floor][dir] = hall[floor][dir] && (!ok || n.clk.Since(done) > config.SnapshotInterval)
+		}
+	}
+	return filtered
+}
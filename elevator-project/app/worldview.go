@@ -0,0 +1,129 @@
+package app
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/record"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Every node broadcasts its worldview, the status of its elevator, every
+// WorldviewBCInterval. Each change makes a new version. A State keyframe
+// carries the full status and goes out every KeyframeInterval, and when
+// another node asks for one. In between a WorldviewDelta carries the changes
+// from the previous version, or nothing when there are none. Every broadcast
+// counts as a heartbeat. A receiver that sees a version it cannot apply a
+// delta to asks for a keyframe.
+
+// keyframeRetry is how long a node waits for a requested keyframe before it
+// asks again.
+const keyframeRetry = 500 * time.Millisecond
+
+// worldviewSender is the version state of the broadcasts of this node.
+type worldviewSender struct {
+	mu           sync.Mutex
+	version      int
+	last         message.ElevatorState // The state at version, sharing no slices with the elevator
+	lastKeyframe time.Time
+	requested    bool // A keyframe has been asked for
+}
+
+// BroadcastWorldview stores the local elevator's status and broadcasts it.
+func (n *Node) BroadcastWorldview() {
+	record.AddTimer(record.WorldviewTimer)
+	status := n.elevator.GetStatus()
+	n.store.UpdateStatus(status)
+	current := *stateData(status)
+	current.RequestMatrix = message.CopyRequestMatrix(current.RequestMatrix)
+	current.ServedFloors = slices.Clone(current.ServedFloors)
+
+	w := &n.worldview
+	w.mu.Lock()
+	delta := message.Diff(w.last, current)
+	if !delta.Empty() {
+		w.version++
+	}
+	w.last = current
+	msg := message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+	}
+	if w.requested || w.lastKeyframe.IsZero() || n.clk.Since(w.lastKeyframe) >= config.KeyframeInterval {
+		msg.Payload = message.State{Version: w.version, Status: current}
+		w.lastKeyframe = n.clk.Now()
+		w.requested = false
+	} else {
+		update := message.WorldviewDelta{Version: w.version}
+		if !delta.Empty() {
+			update.Delta = &delta
+		}
+		msg.Payload = update
+	}
+	w.mu.Unlock()
+
+	n.MsgTx <- msg
+}
+
+// HandleState stores the status in a State keyframe.
+func (n *Node) HandleState(msg message.Message, keyframe message.State) {
+	status := statusOf(&keyframe.Status)
+	status.ElevatorID = msg.ElevatorID
+	n.store.UpdateStatus(status)
+	if msg.ElevatorID != n.ID {
+		n.worldviewVersions[msg.ElevatorID] = keyframe.Version
+		delete(n.keyframeRequests, msg.ElevatorID)
+	}
+}
+
+// HandleWorldviewDelta applies a delta to the stored status of its sender if
+// it follows the version already known, and asks for a keyframe if a version
+// has been missed.
+func (n *Node) HandleWorldviewDelta(msg message.Message, update message.WorldviewDelta) {
+	if msg.ElevatorID == n.ID {
+		return
+	}
+	known, ok := n.worldviewVersions[msg.ElevatorID]
+	switch {
+	case ok && update.Version <= known:
+		// A heartbeat, or a late or duplicate delta.
+	case ok && update.Version == known+1 && update.Delta != nil:
+		data := stateData(n.store.GetAll()[msg.ElevatorID])
+		update.Delta.Apply(data)
+		status := statusOf(data)
+		status.ElevatorID = msg.ElevatorID
+		n.store.UpdateStatus(status)
+		n.worldviewVersions[msg.ElevatorID] = update.Version
+	default:
+		n.requestKeyframe(msg.ElevatorID, known, update.Version)
+	}
+	n.store.UpdateHeartbeat(msg.ElevatorID)
+}
+
+// requestKeyframe asks elevator id for a keyframe, unless it has been asked
+// recently.
+func (n *Node) requestKeyframe(id int, known int, version int) {
+	if asked, ok := n.keyframeRequests[id]; ok && n.clk.Since(asked) < keyframeRetry {
+		return
+	}
+	n.keyframeRequests[id] = n.clk.Now()
+	n.log().Debug("missed a worldview version, asking for a keyframe", "elevator", id, "known", known, "version", version)
+	n.MsgTx <- message.Message{
+		ElevatorID: n.ID,
+		MsgID:      n.msgID.Next(),
+		Payload:    message.KeyframeRequest{TargetID: id},
+		To:         []int{id},
+	}
+}
+
+// HandleKeyframeRequest makes the next broadcast of this node a keyframe, if
+// it was asked for.
+func (n *Node) HandleKeyframeRequest(msg message.Message, request message.KeyframeRequest) {
+	if request.TargetID != n.ID {
+		return
+	}
+	n.worldview.mu.Lock()
+	n.worldview.requested = true
+	n.worldview.mu.Unlock()
+}
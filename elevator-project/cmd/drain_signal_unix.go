@@ -0,0 +1,11 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignals drain the node, see app.Node.Drain.
+var drainSignals = []os.Signal{syscall.SIGUSR1}
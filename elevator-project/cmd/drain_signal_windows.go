@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// There is no signal to drain the node on Windows, use the control API.
+var drainSignals []os.Signal
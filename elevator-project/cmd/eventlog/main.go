@@ -0,0 +1,176 @@
+// eventlog merges, filters and summarizes the event logs written by the
+// elevator nodes.
+//
+//	go run ./cmd/eventlog events-1.jsonl events-2.jsonl events-3.jsonl
+//	go run ./cmd/eventlog -kind peer_new,peer_lost -node 2 events-*.jsonl
+//	go run ./cmd/eventlog -order hall-up-2 events-*.jsonl
+package main
+
+import (
+	"elevator-project/pkg/eventlog"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	order := flag.String("order", "", "Reconstruct the history of one order, e.g. hall-up-2 or cab-3-1 (cab call to floor 1 in elevator 3)")
+	kinds := flag.String("kind", "", "Comma separated list of event kinds to show")
+	node := flag.Int("node", 0, "Only show events logged by this node")
+	elevator := flag.Int("elevator", 0, "Only show events about this elevator")
+	since := flag.String("since", "", "Only show events at or after this time (RFC3339 or 15:04:05)")
+	until := flag.String("until", "", "Only show events before this time (RFC3339 or 15:04:05)")
+	asJSON := flag.Bool("json", false, "Print the merged events as JSON lines")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: eventlog [flags] file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var logs [][]eventlog.Event
+	for _, path := range flag.Args() {
+		events, err := eventlog.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "eventlog:", err)
+			os.Exit(1)
+		}
+		logs = append(logs, events)
+	}
+	events := eventlog.Merge(logs...)
+	if len(events) == 0 {
+		return
+	}
+
+	day := events[0].Time
+	sinceTime, err := parseTime(*since, day)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "eventlog: -since:", err)
+		os.Exit(2)
+	}
+	untilTime, err := parseTime(*until, day)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "eventlog: -until:", err)
+		os.Exit(2)
+	}
+
+	wanted := make(map[eventlog.Kind]bool)
+	for _, k := range strings.Split(*kinds, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			wanted[eventlog.Kind(k)] = true
+		}
+	}
+
+	var selected []eventlog.Event
+	for _, e := range events {
+		switch {
+		case *order != "" && e.OrderKey() != *order:
+		case len(wanted) > 0 && !wanted[e.Kind]:
+		case *node != 0 && e.Node != *node:
+		case *elevator != 0 && e.Elevator != *elevator:
+		case !sinceTime.IsZero() && e.Time.Before(sinceTime):
+		case !untilTime.IsZero() && !e.Time.Before(untilTime):
+		default:
+			selected = append(selected, e)
+		}
+	}
+
+	for _, e := range selected {
+		if *asJSON {
+			line, _ := json.Marshal(e)
+			fmt.Println(string(line))
+		} else {
+			fmt.Println(format(e))
+		}
+	}
+
+	if *order != "" && !*asJSON {
+		summarize(*order, selected)
+	}
+}
+
+// parseTime accepts RFC3339 or a time of day, taken to be on the same day as
+// day.
+func parseTime(s string, day time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("15:04:05", s, day.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	y, m, d := day.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, day.Location()), nil
+}
+
+func format(e eventlog.Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s node=%d %-16s", e.Time.Format("15:04:05.000"), e.Node, e.Kind)
+	if key := e.OrderKey(); key != "" {
+		fmt.Fprintf(&b, " order=%s", key)
+	}
+	if e.Elevator != 0 {
+		fmt.Fprintf(&b, " elevator=%d", e.Elevator)
+	}
+	switch e.Kind {
+	case eventlog.FSMTransition:
+		fmt.Fprintf(&b, " floor=%d %s->%s", e.Floor, e.From, e.To)
+	case eventlog.PeerNew, eventlog.PeerLost, eventlog.PeerSuspected, eventlog.PeerRecovered, eventlog.PeerRestarted:
+		fmt.Fprintf(&b, " peer=%s", e.Peer)
+	case eventlog.MasterChanged, eventlog.HandoverDone:
+		fmt.Fprintf(&b, " master=%d", e.Master)
+	}
+	if e.MsgID != 0 {
+		fmt.Fprintf(&b, " msgID=%d", e.MsgID)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, " %s", e.Detail)
+	}
+	return b.String()
+}
+
+// summarize prints how long each press of the order waited to be delegated
+// and completed. An order key can be pressed and completed many times over a
+// session, so each completion closes the current press.
+func summarize(key string, events []eventlog.Event) {
+	fmt.Printf("\nHistory of %s:\n", key)
+	var pressed time.Time
+	for _, e := range events {
+		switch e.Kind {
+		case eventlog.ButtonPressed:
+			if pressed.IsZero() {
+				pressed = e.Time
+				fmt.Printf("  %s pressed on node %d\n", e.Time.Format("15:04:05.000"), e.Node)
+			}
+		case eventlog.OrderDelegated:
+			fmt.Printf("  %s delegated to elevator %d%s\n", e.Time.Format("15:04:05.000"), e.Elevator, since(pressed, e.Time))
+		case eventlog.OrderCompleted, eventlog.OrderCancelled:
+			verb := "completed by"
+			if e.Kind == eventlog.OrderCancelled {
+				verb = "cancelled for"
+			}
+			fmt.Printf("  %s %s elevator %d%s\n", e.Time.Format("15:04:05.000"), verb, e.Elevator, since(pressed, e.Time))
+			pressed = time.Time{}
+		}
+	}
+	if !pressed.IsZero() {
+		fmt.Printf("  still pending\n")
+	}
+}
+
+func since(start, t time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" (+%s)", t.Sub(start).Round(time.Millisecond))
+}
@@ -2,40 +2,299 @@
 package main
 
 import (
+	"context"
 	"elevator-project/app"
+	"elevator-project/pkg/HRA"
+	"elevator-project/pkg/clock"
 	"elevator-project/pkg/config"
+	"elevator-project/pkg/control"
+	"elevator-project/pkg/dashboard"
 	"elevator-project/pkg/drivers"
 	"elevator-project/pkg/elevator"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/failure"
+	"elevator-project/pkg/logging"
 	"elevator-project/pkg/message"
+	"elevator-project/pkg/metrics"
 	"elevator-project/pkg/network/bcast"
+	"elevator-project/pkg/network/conn"
+	"elevator-project/pkg/network/faults"
+	"elevator-project/pkg/network/peers"
+	"elevator-project/pkg/network/unicast"
+	"elevator-project/pkg/orders"
+	"elevator-project/pkg/record"
+	"elevator-project/pkg/utils"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
+var log = logging.For("main")
+
 func main() {
 	flag.IntVar(&config.ElevatorID, "id", 0, "ElevatorID")
+	flag.IntVar(&config.NumFloors, "floors", config.NumFloors, "Number of floors in the building")
+	flag.IntVar(&config.NumElevators, "elevators", config.NumElevators, "Number of elevators in the cluster, with IDs from 1")
+	flag.StringVar(&config.HallAssigner, "assigner", config.HallAssigner, "Hall request assignment strategy: "+strings.Join(HRA.Strategies(), ", "))
+	served := flag.String("served", "", "Comma separated list of floors served by this elevator (default all)")
+	eventLog := flag.String("events", config.EventLogPath, "Event log file, formatted with the elevator ID (empty disables)")
+	logLevels := flag.String("log", "info", "Log levels, e.g. \"info\" or \"debug,hra=warn,peers=error\"")
+	recording := flag.String("record", "", "Record every input of this node to a file, formatted with the elevator ID")
+	replayPath := flag.String("replay", "", "Replay a recording made with -record instead of running")
+	networkFaults := flag.String("faults", "", "Network faults to inject, e.g. \"loss=20,delay=50ms,jitter=20ms,partition=a\"")
+	cabCalls := flag.String("cabcalls", config.CabCallsPath, "File cab calls are kept in across a graceful restart, formatted with the elevator ID (empty disables)")
+	flag.BoolVar(&config.Unicast, "unicast", config.Unicast, "Send directed messages, such as OrderDelegation and Ack, over unicast UDP instead of broadcasting them")
+	flag.StringVar(&config.MulticastGroup, "multicast", config.MulticastGroup, "Multicast group to send broadcast messages and peer beacons to instead of broadcasting, e.g. 239.255.0.42 or ff15::42")
+	flag.IntVar(&config.MulticastTTL, "ttl", config.MulticastTTL, "Hops multicast datagrams may take")
+	flag.StringVar(&config.MulticastInterface, "iface", config.MulticastInterface, "Network interface to join the multicast group on (default chosen by the system)")
+	flag.IntVar(&config.ProtocolVersion, "protocol", config.ProtocolVersion, "Highest message protocol version to speak, pinned to the version of the oldest node while upgrading a cluster (default the newest)")
 	flag.Parse()
 
-	var msgIDcounter message.MsgID
+	log = log.With("node", config.ElevatorID)
+	if err := logging.SetLevels(*logLevels); err != nil {
+		log.Error("invalid -log", "err", err)
+		os.Exit(1)
+	}
+
+	if *replayPath != "" {
+		if err := replay(*replayPath); err != nil {
+			log.Error("replay failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *eventLog != "" {
+		path := withID(*eventLog)
+		if err := eventlog.Open(path, config.ElevatorID); err != nil {
+			log.Error("could not open event log", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := HRA.CheckStrategy(config.HallAssigner); err != nil {
+		log.Error("invalid -assigner", "err", err)
+		os.Exit(1)
+	}
+
+	if *served != "" {
+		floors, err := utils.ParseFloorList(*served)
+		if err != nil {
+			log.Error("invalid -served", "err", err)
+			os.Exit(1)
+		}
+		config.ServedFloors[config.ElevatorID] = floors
+	}
+
+	faultConfig, err := faults.Parse(*networkFaults)
+	if err != nil {
+		log.Error("invalid -faults", "err", err)
+		os.Exit(1)
+	}
+	faults.Default.Set(faultConfig)
+
+	if config.MulticastGroup != "" {
+		if ip := net.ParseIP(config.MulticastGroup); ip == nil || !ip.IsMulticast() {
+			log.Error("invalid -multicast, not a multicast address", "group", config.MulticastGroup)
+			os.Exit(1)
+		}
+	}
+
+	if config.ProtocolVersion != 0 && !message.Speaks(config.ProtocolVersion) {
+		log.Error("invalid -protocol", "version", config.ProtocolVersion, "min", message.MinProtocolVersion, "max", message.ProtocolVersion)
+		os.Exit(1)
+	}
+
+	// The messages of the newest protocol version spoken have to fit in a
+	// datagram; an older version negotiated with an older node may not.
+	protocol := message.ProtocolVersion
+	if config.ProtocolVersion != 0 {
+		protocol = config.ProtocolVersion
+	}
+	for _, msg := range message.Largest(config.NumFloors, config.NumElevators, protocol) {
+		if err := bcast.Fits(msg); err != nil {
+			log.Error("too many floors or elevators, messages do not fit in a datagram", "floors", config.NumFloors, "elevators", config.NumElevators, "type", msg.Type(), "err", err)
+			os.Exit(1)
+		}
+	}
 
 	drivers.Init(config.ElevatorAddresses[config.ElevatorID], config.NumFloors)
 
-	msgTx := make(chan message.Message)
-	msgRx := make(chan message.Message)
-	ackChan := make(chan message.Message)
-	go bcast.Transmitter(config.BCport, msgTx)
-	go bcast.Receiver(config.BCport, msgRx)
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	drainSignal := make(chan os.Signal, 1)
+	if len(drainSignals) > 0 {
+		signal.Notify(drainSignal, drainSignals...)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node := app.NewNode(config.ElevatorID, clock.Real{})
+
+	netTx := make(chan message.Message)
+	netRx := make(chan message.Message)
+	peerUpdates := make(chan peers.PeerUpdate)
+	peerTxEnable := make(chan bool)
+	bcConn, bcAddr, err := dial(config.BCport)
+	if err != nil {
+		log.Error("could not open the broadcast conn", "port", config.BCport, "err", err)
+		os.Exit(1)
+	}
+	defer bcConn.Close()
+	p2pConn, p2pAddr, err := dial(config.P2Pport)
+	if err != nil {
+		log.Error("could not open the peer conn", "port", config.P2Pport, "err", err)
+		os.Exit(1)
+	}
+	defer p2pConn.Close()
+	go bcast.TransmitterOn(ctx, bcConn, bcAddr, netTx)
+	go bcast.ReceiverOn(ctx, bcConn, netRx)
+	if config.Unicast {
+		if err := startUnicast(ctx, node, netRx); err != nil {
+			log.Error("could not start unicast", "err", err)
+			os.Exit(1)
+		}
+	}
+	go peers.TransmitterOn(ctx, p2pConn, p2pAddr, node.Beacon(), node.BeaconUpdates(), peerTxEnable, clock.Real{})
+	go peers.ReceiverOn(ctx, p2pConn, peerUpdates, failure.New(failure.DefaultConfig(), clock.Real{}))
+	node.Connect(ctx, netTx, netRx, peerUpdates)
+
+	elevator := elevator.NewElevator(config.ElevatorID, node.MsgTx, node.MsgCounter())
+	if *recording != "" {
+		path := withID(*recording)
+		header := record.Header{
+			Node:         config.ElevatorID,
+			NumFloors:    config.NumFloors,
+			ServedFloors: config.ServedFloors[config.ElevatorID],
+			StartFloor:   elevator.GetStatus().CurrentFloor,
+			MasterID:     node.CurrentMasterID,
+		}
+		if err := record.Open(path, header); err != nil {
+			log.Error("could not open recording", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	buttons := make(chan drivers.ButtonEvent)
+	floors := make(chan int)
+	obstruction := make(chan bool)
+	stop := make(chan bool)
+	go drivers.PollButtons(buttons)
+	go drivers.PollFloorSensor(floors)
+	go drivers.PollObstructionSwitch(obstruction)
+	go drivers.PollStopButton(stop)
+
+	node.SetElevator(elevator, drivers.Hardware{})
+	node.Start(ctx, app.Inputs{Buttons: buttons, Floors: floors, Obstruction: obstruction, Stop: stop})
+
+	if *cabCalls != "" {
+		cab, err := orders.TakeCabCalls(withID(*cabCalls))
+		if err != nil {
+			log.Error("could not restore cab calls", "err", err)
+		}
+		node.RestoreCabCalls(cab)
+	}
+
+	http.Handle("/loglevel", logging.LevelHandler())
+	http.Handle("/metrics", metrics.Handler(node.Metrics()))
+	http.Handle("/api/faults", faults.Handler())
+	http.Handle("/api/", control.Handler(app.NewOperator(node)))
+	http.Handle("/", dashboard.Handler(node.DashboardSnapshot, config.DashboardInterval))
+	server := &http.Server{Addr: config.HTTPAddresses[config.ElevatorID]}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("http server stopped", "err", err)
+		}
+	}()
+
+	// SIGINT and SIGTERM shut the node down, handing its hall orders off.
+	// Draining, for a restart on a new version, serves them first.
+	leave, timeout := node.Shutdown, config.ShutdownTimeout
+	select {
+	case <-signals.Done():
+		log.Info("signal received", "timeout", timeout)
+	case <-drainSignal:
+		leave, timeout = node.Drain, config.DrainTimeout
+		log.Info("drain signal received", "timeout", timeout)
+	case <-node.DrainRequested():
+		leave, timeout = node.Drain, config.DrainTimeout
+		log.Info("drain requested", "timeout", timeout)
+	}
+	stopSignals() // A second signal kills the process
+	signal.Stop(drainSignal)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	cab := leave(shutdownCtx)
+	if *cabCalls != "" {
+		if err := orders.SaveCabCalls(withID(*cabCalls), cab); err != nil {
+			log.Error("could not save cab calls", "err", err)
+		}
+	}
 
-	elevator := elevator.NewElevator(config.ElevatorID, msgTx, &msgIDcounter)
-	go app.MessageHandler(msgRx, ackChan, msgTx, elevator)
-	go app.StartHeartbeatBC(msgTx)
-	go elevator.Run()
-	go app.MonitorSystemInputs(elevator, msgTx)
-	go app.P2Pmonitor()
-	go app.StartWorldviewBC(elevator, msgTx, &msgIDcounter)
+	cancel()
+	waited := make(chan struct{})
+	go func() {
+		node.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-shutdownCtx.Done():
+		log.Warn("node did not stop in time")
+	}
+	server.Shutdown(shutdownCtx)
+	drivers.SetMotorDirection(drivers.MD_Stop)
+	record.Close()
+	eventlog.Close()
+	log.Info("shut down")
+}
 
-	if config.ElevatorID == 1 {
-		app.IsMaster = true
+// withID formats path with the elevator ID if it contains a %d.
+func withID(path string) string {
+	if strings.Contains(path, "%d") {
+		return fmt.Sprintf(path, config.ElevatorID)
 	}
+	return path
+}
 
-	select {}
+// dial opens the conn for port, see conn.Dial, with the network faults of
+// the -faults flag.
+func dial(port int) (net.PacketConn, net.Addr, error) {
+	pc, addr, err := conn.Dial(port)
+	if err != nil {
+		return nil, nil, err
+	}
+	return faults.Wrap(pc), addr, nil
+}
+
+// startUnicast listens for directed messages on the unicast port of this
+// node, passing them on to netRx, and makes node send its directed messages
+// there. The addresses in config.UDPAddresses are used until the peers have
+// been discovered.
+func startUnicast(ctx context.Context, node *app.Node, netRx chan<- message.Message) error {
+	pc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", config.UnicastPort(config.ElevatorID)))
+	if err != nil {
+		return err
+	}
+	conn := faults.Wrap(pc)
+	book := unicast.NewAddressBook(unicast.UDPResolver(config.UnicastPort))
+	for id, addr := range config.UDPAddresses {
+		if udp, err := net.ResolveUDPAddr("udp4", addr); err == nil {
+			book.Set(id, udp)
+		}
+	}
+	uniTx := make(chan unicast.Packet)
+	go func() {
+		defer conn.Close()
+		unicast.Transmitter(ctx, conn, book, uniTx)
+	}()
+	go unicast.Receiver(ctx, conn, netRx)
+	node.SetUnicast(uniTx, book, conn.LocalAddr())
+	return nil
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"elevator-project/app"
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/elevator"
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/record"
+)
+
+var replayLog = logging.For("replay")
+
+// replayHardware logs what the elevator would have done to the hardware.
+type replayHardware struct{}
+
+func (replayHardware) SetMotorDirection(dir drivers.MotorDirection) {
+	replayLog.Info("motor", "dir", dir)
+}
+
+func (replayHardware) SetButtonLamp(button drivers.ButtonType, floor int, value bool) {
+	replayLog.Info("button lamp", "button", button, "floor", floor, "value", value)
+}
+
+func (replayHardware) SetFloorIndicator(floor int) {
+	replayLog.Info("floor indicator", "floor", floor)
+}
+
+func (replayHardware) SetDoorOpenLamp(value bool) {
+	replayLog.Info("door lamp", "value", value)
+}
+
+func (replayHardware) SetStopLamp(value bool) {
+	replayLog.Info("stop lamp", "value", value)
+}
+
+// GetFloor is not used during replay, the recorded floor arrivals carry the
+// floor.
+func (replayHardware) GetFloor() int {
+	return -1
+}
+
+// replay feeds a recording made with -record back into the elevator and the
+// message handler of a node. Time only moves to the time of each recorded
+// input, and the node's decisions are logged instead of sent.
+func replay(path string) error {
+	// The messages are validated when they are read, against the config of
+	// the recorded node.
+	header, err := record.ReadHeader(path)
+	if err != nil {
+		return err
+	}
+	config.ElevatorID = header.Node
+	config.NumFloors = header.NumFloors
+	if header.ServedFloors != nil {
+		config.ServedFloors[header.Node] = header.ServedFloors
+	}
+
+	_, entries, err := record.Read(path)
+	if err != nil {
+		return err
+	}
+	replayLog = replayLog.With("node", header.Node)
+
+	clk := clock.NewVirtual(entries[0].Time)
+	node := app.NewNode(header.Node, clk)
+	node.CurrentMasterID = header.MasterID
+	node.IsMaster = header.Node == header.MasterID
+	e := elevator.NewElevatorWith(header.Node, header.StartFloor, replayHardware{}, clk, node.MsgTx, node.MsgCounter())
+	node.SetElevator(e, replayHardware{})
+
+	// settle lets the elevator handle everything pending and logs what the
+	// node sent in the meantime.
+	settle := func() {
+		for e.Step() {
+		}
+		for {
+			select {
+			case msg := <-node.MsgTx:
+				replayLog.Info("sent", "type", msg.Type(), "msgID", msg.MsgID, "payload", msg.Payload)
+			default:
+				return
+			}
+		}
+	}
+
+	replayLog.Info("replaying", "path", path, "entries", len(entries)-1, "startFloor", header.StartFloor, "master", header.MasterID)
+	for _, entry := range entries[1:] {
+		clk.AdvanceTo(entry.Time)
+		settle()
+
+		switch entry.Kind {
+		case record.Message:
+			node.HandleMessage(*entry.Message)
+		case record.Button:
+			if err := node.HandleButtonPress(*entry.Button); err != nil {
+				replayLog.Warn("ignoring button press", "err", err, "floor", entry.Button.Floor, "button", entry.Button.Button)
+			}
+		case record.Floor:
+			e.FloorReached(entry.Floor)
+		case record.Obstruction:
+			node.HandleObstruction(entry.Value)
+		case record.StopButton:
+			// The stop button is not handled yet.
+		case record.Timer:
+			// The door timer fires by itself when the clock reaches it, the
+			// entry only marks when it fired. The others are run here.
+			switch entry.Timer {
+			case record.WorldviewTimer:
+				node.BroadcastWorldview()
+			case record.HandoverTimer:
+				node.HandoverTimerElapsed()
+			case record.SnapshotTimer:
+				node.SnapshotTimerElapsed()
+			}
+		case record.Liveness:
+			node.HandleLiveness(*entry.Failure)
+		default:
+			replayLog.Warn("unknown entry", "kind", entry.Kind)
+		}
+		settle()
+	}
+	replayLog.Info("replay done", "status", e.GetStatus())
+	return nil
+}
@@ -1,5 +1,5 @@
 module elevator-project
 
-go 1.16
+go 1.21
 
 replace elevator-project => ./
@@ -1,15 +1,28 @@
 package HRA
 
 import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/metrics"
 	"elevator-project/pkg/state"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+var log = logging.For("hra")
+
+var nativeOnce sync.Once
+
+var runSeconds = metrics.NewHistogram("elevator_hra_run_seconds", "Time spent assigning hall requests.", metrics.DefaultBuckets)
+
 type HRAElevState struct {
 	Behavior    string `json:"behaviour"`
 	Floor       int    `json:"floor"`
@@ -22,25 +35,122 @@ type HRAInput struct {
 	States       map[string]HRAElevState `json:"states"`
 }
 
+// HRARun assigns the hall requests in the store to the elevators. A hall
+// request is only given to elevators that are in service, reachable and serve
+// its floor, so the requests are split into groups sharing the same set of
+// eligible elevators and the assigner is run once per group. A draining
+// elevator keeps the hall requests it has, and is only given others no other
+// elevator can take.
 func HRARun(st *state.Store) (map[string][][2]bool, error) {
+	start := time.Now()
+	defer func() { runSeconds.Observe(time.Since(start).Seconds()) }()
+
+	assign, ok := strategies[config.HallAssigner]
+	if !ok {
+		return nil, fmt.Errorf("unknown hall assigner %q", config.HallAssigner)
+	}
+
 	allElevators := st.GetAll()
-	statesMap := make(map[string]HRAElevState)
-	for id, elev := range allElevators {
-		dirString := directionIntToString(elev.Direction)
-		stateString := stateIntToString(elev.State)
-		statesMap[strconv.Itoa(id)] = HRAElevState{
-			Behavior:    stateString,
-			Floor:       elev.CurrentFloor,
-			Direction:   dirString,
-			CabRequests: elev.RequestMatrix.CabRequests,
+	hallRequests := st.GetHallOrders(0)
+
+	ids := make([]int, 0, len(allElevators))
+	for id := range allElevators {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	output := make(map[string][][2]bool)
+	for _, id := range ids {
+		output[strconv.Itoa(id)] = make([][2]bool, len(hallRequests))
+	}
+
+	groups := make(map[string]*HRAInput)
+	for floor, req := range hallRequests {
+		for dir, active := range req {
+			if !active {
+				continue
+			}
+			if id, ok := drainingHolder(st, allElevators, ids, floor, dir); ok {
+				output[strconv.Itoa(id)][floor][dir] = true
+				continue
+			}
+			eligible := eligibleFor(st, allElevators, ids, floor, false)
+			if len(eligible) == 0 {
+				eligible = eligibleFor(st, allElevators, ids, floor, true)
+			}
+			if len(eligible) == 0 {
+				log.Warn("no reachable elevator in service serves floor, hall request left unassigned", "floor", floor, "dir", dir)
+				continue
+			}
+
+			key := strings.Join(eligible, ",")
+			group, ok := groups[key]
+			if !ok {
+				group = &HRAInput{
+					HallRequests: make([][2]bool, len(hallRequests)),
+					States:       make(map[string]HRAElevState),
+				}
+				for _, id := range eligible {
+					elevID, _ := strconv.Atoi(id)
+					elev := allElevators[elevID]
+					group.States[id] = HRAElevState{
+						Behavior:    stateIntToString(elev.State),
+						Floor:       elev.CurrentFloor,
+						Direction:   directionIntToString(elev.Direction),
+						CabRequests: elev.RequestMatrix.CabRequests,
+					}
+				}
+				groups[key] = group
+			}
+			group.HallRequests[floor][dir] = true
 		}
 	}
 
-	input := HRAInput{
-		HallRequests: st.HallRequests,
-		States:       statesMap,
+	for _, input := range groups {
+		assigned, err := assign(*input)
+		if err != nil {
+			return nil, err
+		}
+		for id, reqs := range assigned {
+			for floor := range reqs {
+				output[id][floor][0] = output[id][floor][0] || reqs[floor][0]
+				output[id][floor][1] = output[id][floor][1] || reqs[floor][1]
+			}
+		}
 	}
 
+	log.Info("hall requests assigned", "assignment", output)
+
+	return output, nil
+}
+
+// eligibleFor returns the elevators that can take a hall request at floor,
+// leaving out the draining ones unless withDraining is set.
+func eligibleFor(st *state.Store, all map[int]state.ElevatorStatus, ids []int, floor int, withDraining bool) []string {
+	eligible := []string{}
+	for _, id := range ids {
+		if all[id].InService && st.Reachable(id) && all[id].ServesFloor(floor) && (withDraining || !st.Draining(id)) {
+			eligible = append(eligible, strconv.Itoa(id))
+		}
+	}
+	return eligible
+}
+
+// drainingHolder returns the draining elevator that already has the hall
+// request at floor and dir, if any.
+func drainingHolder(st *state.Store, all map[int]state.ElevatorStatus, ids []int, floor, dir int) (int, bool) {
+	for _, id := range ids {
+		hall := all[id].RequestMatrix.HallRequests
+		if st.Draining(id) && all[id].InService && st.Reachable(id) && floor < len(hall) && hall[floor][dir] {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// runAssigner runs the hall_request_assigner executable on a single input, or
+// the Go version when the executable is missing.
+func runAssigner(input HRAInput) (map[string][][2]bool, error) {
 	PrintHRAInput(input)
 	jsonBytes, err := json.Marshal(input)
 	if err != nil {
@@ -49,20 +159,30 @@ func HRARun(st *state.Store) (map[string][][2]bool, error) {
 
 	// Select the executable and command based on the OS.
 	var cmd *exec.Cmd
+	var executable string
 	switch runtime.GOOS {
 	case "windows":
-		cmd = exec.Command("../hall_request_assigner.exe", "-i", string(jsonBytes))
+		executable = "../hall_request_assigner.exe"
+		cmd = exec.Command(executable, "-i", string(jsonBytes))
 	case "darwin":
-		cmd = exec.Command("wine", "../hall_request_assigner.exe", "-i", string(jsonBytes))
+		executable = "../hall_request_assigner.exe"
+		cmd = exec.Command("wine", executable, "-i", string(jsonBytes))
 	case "linux":
-		cmd = exec.Command("../hall_request_assigner", "-i", string(jsonBytes))
+		executable = "../hall_request_assigner"
+		cmd = exec.Command(executable, "-i", string(jsonBytes))
 	default:
-		return nil, fmt.Errorf("OS not supported")
+		return assignNative(input), nil
+	}
+	if _, err := os.Stat(executable); err != nil {
+		nativeOnce.Do(func() {
+			log.Warn("hall_request_assigner not found, using the Go assigner", "path", executable)
+		})
+		return assignNative(input), nil
 	}
 
 	ret, err := cmd.CombinedOutput()
 	rawOutput := string(ret)
-	fmt.Println("Raw output from hall_request_assigner:", rawOutput)
+	log.Debug("hall_request_assigner output", "raw", rawOutput)
 	if err != nil {
 		return nil, fmt.Errorf("exec.Command error: %v, output: %s", err, rawOutput)
 	}
@@ -80,11 +200,6 @@ func HRARun(st *state.Store) (map[string][][2]bool, error) {
 		return nil, fmt.Errorf("json.Unmarshal error: %v", err)
 	}
 
-	fmt.Println("Master sending the output:")
-	for k, v := range output {
-		fmt.Printf("%6v : %+v\n", k, v)
-	}
-
 	return output, nil
 }
 
@@ -118,21 +233,7 @@ func stateIntToString(state int) string {
 	}
 }
 
+// PrintHRAInput logs the assigner input at debug level.
 func PrintHRAInput(input HRAInput) {
-	fmt.Println("HRA Input:")
-
-	fmt.Println("Hall Requests:")
-	for floor, req := range input.HallRequests {
-		// Each req is an array of two booleans: [Up, Down]
-		fmt.Printf("  Floor %d: Up: %t, Down: %t\n", floor, req[0], req[1])
-	}
-
-	fmt.Println("States:")
-	for id, state := range input.States {
-		fmt.Printf("  Elevator %s:\n", id)
-		fmt.Printf("    Behavior   : %s\n", state.Behavior)
-		fmt.Printf("    Floor      : %d\n", state.Floor)
-		fmt.Printf("    Direction  : %s\n", state.Direction)
-		fmt.Printf("    CabRequests: %v\n", state.CabRequests)
-	}
+	log.Debug("hall_request_assigner input", "hallRequests", input.HallRequests, "states", input.States)
 }
@@ -0,0 +1,174 @@
+package HRA
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A Go version of the hall_request_assigner, used when the executable is not
+// available. Hall requests are assigned one at a time, in floor order, to the
+// elevator that would be idle soonest with the request added. Ties go to the
+// lowest elevator ID, so the assignment only depends on the input.
+
+const (
+	travelTime   = 2500 * time.Millisecond
+	doorOpenTime = 3 * time.Second
+)
+
+type costElevator struct {
+	behaviour string
+	floor     int
+	direction int
+	requests  [][3]bool // Hall up, hall down and cab
+}
+
+func assignNative(input HRAInput) map[string][][2]bool {
+	ids := make([]string, 0, len(input.States))
+	for id := range input.States {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+
+	numFloors := len(input.HallRequests)
+	elevators := make(map[string]costElevator)
+	output := make(map[string][][2]bool)
+	for _, id := range ids {
+		state := input.States[id]
+		e := costElevator{
+			behaviour: state.Behavior,
+			floor:     state.Floor,
+			requests:  make([][3]bool, numFloors),
+		}
+		switch state.Direction {
+		case "up":
+			e.direction = 1
+		case "down":
+			e.direction = -1
+		}
+		for floor := 0; floor < numFloors && floor < len(state.CabRequests); floor++ {
+			e.requests[floor][2] = state.CabRequests[floor]
+		}
+		elevators[id] = e
+		output[id] = make([][2]bool, numFloors)
+	}
+
+	for floor, dirs := range input.HallRequests {
+		for dir, active := range dirs {
+			if !active || len(ids) == 0 {
+				continue
+			}
+			best := ""
+			var bestCost time.Duration
+			for _, id := range ids {
+				e := elevators[id].with(floor, dir)
+				if cost := timeToIdle(e); best == "" || cost < bestCost {
+					best, bestCost = id, cost
+				}
+			}
+			elevators[best] = elevators[best].with(floor, dir)
+			output[best][floor][dir] = true
+		}
+	}
+	return output
+}
+
+// with returns a copy of e with the request added.
+func (e costElevator) with(floor, button int) costElevator {
+	requests := make([][3]bool, len(e.requests))
+	copy(requests, e.requests)
+	requests[floor][button] = true
+	e.requests = requests
+	return e
+}
+
+// timeToIdle simulates e until it has served all its requests.
+func timeToIdle(e costElevator) time.Duration {
+	requests := make([][3]bool, len(e.requests))
+	copy(requests, e.requests)
+	e.requests = requests
+
+	var duration time.Duration
+	switch e.behaviour {
+	case "idle":
+		e.direction = e.chooseDirection()
+		if e.direction == 0 {
+			return duration
+		}
+	case "moving":
+		duration += travelTime / 2
+		e.floor += e.direction
+	case "doorOpen":
+		duration -= doorOpenTime / 2
+	}
+
+	// Every iteration either moves one floor or stops, so this bounds a
+	// simulation that has gone wrong.
+	for i := 0; i < 4*len(e.requests)+4; i++ {
+		if e.floor < 0 || e.floor >= len(e.requests) {
+			break
+		}
+		if e.shouldStop() {
+			e.requests[e.floor] = [3]bool{}
+			duration += doorOpenTime
+			e.direction = e.chooseDirection()
+			if e.direction == 0 {
+				return duration
+			}
+		}
+		e.floor += e.direction
+		duration += travelTime
+	}
+	return duration
+}
+
+func (e costElevator) requestsAbove() bool {
+	for floor := e.floor + 1; floor < len(e.requests); floor++ {
+		if e.requests[floor] != [3]bool{} {
+			return true
+		}
+	}
+	return false
+}
+
+func (e costElevator) requestsBelow() bool {
+	for floor := 0; floor < e.floor && floor < len(e.requests); floor++ {
+		if e.requests[floor] != [3]bool{} {
+			return true
+		}
+	}
+	return false
+}
+
+func (e costElevator) chooseDirection() int {
+	switch {
+	case e.direction == 1 && e.requestsAbove():
+		return 1
+	case e.direction == 1 && e.requestsBelow():
+		return -1
+	case e.direction == 1:
+		return 0
+	case e.requestsBelow():
+		return -1
+	case e.requestsAbove():
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (e costElevator) shouldStop() bool {
+	r := e.requests[e.floor]
+	switch e.direction {
+	case 1:
+		return r[0] || r[2] || !e.requestsAbove()
+	case -1:
+		return r[1] || r[2] || !e.requestsBelow()
+	default:
+		return true
+	}
+}
@@ -0,0 +1,80 @@
+package HRA
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A strategy assigns the hall requests of an input to the elevators in it.
+// config.HallAssigner selects the one HRARun uses.
+var strategies = map[string]func(HRAInput) (map[string][][2]bool, error){
+	// The hall_request_assigner executable, or native when it is missing.
+	"hra": runAssigner,
+	// The Go version of hall_request_assigner.
+	"native": func(input HRAInput) (map[string][][2]bool, error) {
+		return assignNative(input), nil
+	},
+	// Every request to the closest elevator, as a baseline.
+	"nearest": func(input HRAInput) (map[string][][2]bool, error) {
+		return assignNearest(input), nil
+	},
+}
+
+// Strategies returns the names of the assignment strategies.
+func Strategies() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckStrategy returns an error if name is not an assignment strategy.
+func CheckStrategy(name string) error {
+	if _, ok := strategies[name]; !ok {
+		return fmt.Errorf("unknown hall assigner %q, use one of %s", name, strings.Join(Strategies(), ", "))
+	}
+	return nil
+}
+
+// assignNearest gives every request to the elevator with the fewest floors
+// to go to it, ignoring everything else it has to do. Ties go to the lowest
+// elevator ID.
+func assignNearest(input HRAInput) map[string][][2]bool {
+	ids := make([]string, 0, len(input.States))
+	for id := range input.States {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+
+	output := make(map[string][][2]bool)
+	for _, id := range ids {
+		output[id] = make([][2]bool, len(input.HallRequests))
+	}
+	for floor, dirs := range input.HallRequests {
+		for dir, active := range dirs {
+			if !active || len(ids) == 0 {
+				continue
+			}
+			best, bestDistance := "", 0
+			for _, id := range ids {
+				distance := input.States[id].Floor - floor
+				if distance < 0 {
+					distance = -distance
+				}
+				if best == "" || distance < bestDistance {
+					best, bestDistance = id, distance
+				}
+			}
+			output[best][floor][dir] = true
+		}
+	}
+	return output
+}
@@ -0,0 +1,222 @@
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for timers and timestamps. Real uses the
+// time package; Virtual only moves when told to, which is what replay and
+// simulation need.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Timer is the part of *time.Timer the elevator uses.
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It returns false if the timer has
+	// already fired, in which case the value may still be waiting on C.
+	Stop() bool
+}
+
+// Ticker is the part of *time.Ticker the program uses. Like time.Ticker it
+// drops ticks for slow receivers.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithTimeout is context.WithTimeout with the timeout measured on clk.
+func WithTimeout(parent context.Context, clk Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	timer := clk.NewTimer(d)
+	go func() {
+		select {
+		case <-timer.C():
+			cancel()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return ctx, cancel
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time                   { return time.Now() }
+func (Real) Since(t time.Time) time.Duration  { return time.Since(t) }
+func (Real) Sleep(d time.Duration)            { time.Sleep(d) }
+func (Real) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (Real) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Virtual is a clock that only moves on Advance and AdvanceTo. Timers and
+// tickers fire, and sleepers wake, when the clock is moved past their
+// deadline.
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	period   time.Duration // non-zero for tickers
+	c        chan time.Time
+	stopped  bool
+}
+
+// NewVirtual creates a virtual clock starting at start.
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	return &virtualTimer{clock: v, w: v.addWaiter(d)}
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := v.addWaiter(d)
+	v.mu.Lock()
+	w.period = d
+	v.mu.Unlock()
+	return virtualTicker{&virtualTimer{clock: v, w: w}}
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	return v.addWaiter(d).c
+}
+
+// Sleep blocks until another goroutine has advanced the clock by d.
+func (v *Virtual) Sleep(d time.Duration) {
+	<-v.addWaiter(d).c
+}
+
+// Advance moves the clock forward by d.
+func (v *Virtual) Advance(d time.Duration) {
+	v.AdvanceTo(v.Now().Add(d))
+}
+
+// AdvanceTo moves the clock forward to t, firing every timer and ticker with a
+// deadline at or before t in deadline order. A ticker fires once per period
+// passed, but like time.Ticker it drops ticks nobody has received. Moving
+// backwards does nothing.
+func (v *Virtual) AdvanceTo(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t.Before(v.now) {
+		return
+	}
+
+	for {
+		next := -1
+		for i, w := range v.waiters {
+			if !w.stopped && !w.deadline.After(t) && (next == -1 || w.deadline.Before(v.waiters[next].deadline)) {
+				next = i
+			}
+		}
+		if next == -1 {
+			break
+		}
+		w := v.waiters[next]
+		v.now = w.deadline
+		select {
+		case w.c <- w.deadline:
+		default:
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			v.waiters = append(v.waiters[:next], v.waiters[next+1:]...)
+		}
+	}
+	v.now = t
+}
+
+// NextDeadline returns the earliest pending timer deadline, if any.
+func (v *Virtual) NextDeadline() (time.Time, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var next time.Time
+	found := false
+	for _, w := range v.waiters {
+		if !w.stopped && (!found || w.deadline.Before(next)) {
+			next = w.deadline
+			found = true
+		}
+	}
+	return next, found
+}
+
+func (v *Virtual) addWaiter(d time.Duration) *waiter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	w := &waiter{deadline: v.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- v.now
+		return w
+	}
+	v.waiters = append(v.waiters, w)
+	return w
+}
+
+type virtualTimer struct {
+	clock *Virtual
+	w     *waiter
+}
+
+func (t *virtualTimer) C() <-chan time.Time { return t.w.c }
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.w.stopped {
+		return false
+	}
+	for i, w := range t.clock.waiters {
+		if w == t.w {
+			t.w.stopped = true
+			t.clock.waiters = append(t.clock.waiters[:i], t.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type virtualTicker struct{ t *virtualTimer }
+
+func (t virtualTicker) C() <-chan time.Time { return t.t.C() }
+func (t virtualTicker) Stop()               { t.t.Stop() }
@@ -1,6 +1,10 @@
 package config
 
-import "time"
+import (
+	"net"
+	"strconv"
+	"time"
+)
 
 var ElevatorAddresses = map[int]string{
 	1: "localhost:15555",
@@ -8,6 +12,9 @@ var ElevatorAddresses = map[int]string{
 	3: "localhost:15557",
 }
 
+// UDPAddresses holds the unicast address of each node, used for directed
+// messages when Unicast is set. Once a node has been discovered its host is
+// taken from its beacons, and only the port is used.
 var UDPAddresses = map[int]string{
 	1: "127.0.0.1:8001",
 	2: "127.0.0.1:8002",
@@ -20,9 +27,86 @@ var UDPAckAddresses = map[int]string{
 	3: "127.0.0.1:8013",
 }
 
+// HTTPAddresses is where each node serves its HTTP endpoints (log levels,
+// metrics, dashboard).
+var HTTPAddresses = map[int]string{
+	1: "localhost:8081",
+	2: "localhost:8082",
+	3: "localhost:8083",
+}
+
+// ServedFloors holds the floors each elevator is allowed to stop at, e.g.
+// {2: {1, 2, 3}} keeps elevator 2 out of the basement. An elevator without an
+// entry serves every floor.
+var ServedFloors = map[int][]int{}
+
+// Version is the software version announced in peer beacons, set at build
+// time with -ldflags "-X elevator-project/pkg/config.Version=...".
+var Version = "dev"
+
+// ProtocolVersion pins the highest message protocol version a node speaks,
+// for upgrading a cluster one node at a time. 0 means the newest.
+var ProtocolVersion = 0
+
 var NumFloors = 4
+var NumElevators = 3     // With IDs 1 to NumElevators
+var HallAssigner = "hra" // Hall request assignment strategy, see HRA.Strategies
 var ElevatorID = 0
-var HeartBeatInterval = 100 * time.Millisecond
 var WorldviewBCInterval = 100 * time.Millisecond
+var KeyframeInterval = time.Second // A full worldview is sent at least this often, deltas in between
+var LampSyncInterval = 100 * time.Millisecond
+var DashboardInterval = 250 * time.Millisecond
+var EventLogPath = "events-%d.jsonl"  // Formatted with ElevatorID
+var CabCallsPath = "cabcalls-%d.json" // Formatted with ElevatorID
+var ShutdownTimeout = 30 * time.Second
+var DrainTimeout = 5 * time.Minute    // For a draining node to serve its hall orders
+var HandoverTimeout = 2 * time.Second // How long a new master waits for worldviews before delegating
+var SnapshotInterval = time.Second
+var Unicast = false // Send directed messages, such as OrderDelegation and Ack, to their receivers only
+// MulticastGroup is the group broadcast messages and peer beacons are sent
+// to, e.g. "239.255.0.42" or "ff15::42", instead of the IPv4 limited
+// broadcast address. Empty uses broadcast.
+var MulticastGroup = ""
+var MulticastTTL = 1        // Hops multicast datagrams may take, 1 keeps them on the local network
+var MulticastInterface = "" // Interface to join MulticastGroup on and send from, empty for the default
+// Failure detection thresholds, see failure.Detector. With peer beacons every
+// 15ms a silent peer is suspected after about 0.33s and dead after about
+// 0.5s; irregular beacons push both later.
+var FailureSuspectPhi = 5.0
+var FailureDeadPhi = 12.0
+var FailureMinStdDev = 75 * time.Millisecond
+var FailureAcceptablePause = time.Duration(0)
+var FailureFirstInterval = 100 * time.Millisecond
 var BCport = 15024
 var P2Pport = 16024
+
+// ServedFloorMask returns a NumFloors long slice where index i is true if the
+// elevator serves floor i.
+func ServedFloorMask(elevatorID int) []bool {
+	mask := make([]bool, NumFloors)
+	floors, ok := ServedFloors[elevatorID]
+	if !ok {
+		for i := range mask {
+			mask[i] = true
+		}
+		return mask
+	}
+	for _, floor := range floors {
+		if floor >= 0 && floor < NumFloors {
+			mask[floor] = true
+		}
+	}
+	return mask
+}
+
+// UnicastPort returns the port elevatorID listens on for directed messages.
+func UnicastPort(elevatorID int) int {
+	if addr, ok := UDPAddresses[elevatorID]; ok {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			if p, err := strconv.Atoi(port); err == nil {
+				return p
+			}
+		}
+	}
+	return 8000 + elevatorID
+}
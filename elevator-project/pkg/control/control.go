@@ -0,0 +1,144 @@
+package control
+
+import (
+	"elevator-project/pkg/dashboard"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/logging"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var log = logging.For("control")
+
+// Operator is what the control API can do to the cluster through this node.
+type Operator interface {
+	// PressButton acts as if a button was pressed on this node's panel.
+	PressButton(be drivers.ButtonEvent) error
+	// CancelOrder removes an order without serving it. elevatorID selects the
+	// elevator for cab orders and is ignored for hall orders.
+	CancelOrder(elevatorID int, be drivers.ButtonEvent) error
+	SetInService(elevatorID int, inService bool) error
+	HandOverMaster(elevatorID int) error
+	// Drain drains this node for a restart: it serves its hall orders
+	// without taking new ones, and the process then exits keeping its cab
+	// calls.
+	Drain() error
+	State() dashboard.Snapshot
+}
+
+// Handler serves the control API. All commands are POSTs with form values,
+// e.g.
+//
+//	curl -d floor=2 -d button=up       localhost:8081/api/call
+//	curl -d floor=1 -d button=cab      localhost:8081/api/call
+//	curl -d floor=2 -d button=up       localhost:8081/api/cancel
+//	curl -d elevator=2 -d button=cab -d floor=3 localhost:8081/api/cancel
+//	curl -d elevator=2 -d inService=false localhost:8081/api/service
+//	curl -d elevator=3                 localhost:8081/api/master
+//	curl -X POST                       localhost:8081/api/drain
+//	curl localhost:8081/api/state
+func Handler(op Operator) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/call", post(func(r *http.Request) error {
+		be, err := buttonEvent(r)
+		if err != nil {
+			return err
+		}
+		log.Info("operator call", "floor", be.Floor, "button", be.Button, "remote", r.RemoteAddr)
+		return op.PressButton(be)
+	}))
+
+	mux.HandleFunc("/api/cancel", post(func(r *http.Request) error {
+		be, err := buttonEvent(r)
+		if err != nil {
+			return err
+		}
+		elevatorID := 0
+		if be.Button == drivers.BT_Cab {
+			if elevatorID, err = intValue(r, "elevator"); err != nil {
+				return err
+			}
+		}
+		log.Info("operator cancel", "floor", be.Floor, "button", be.Button, "elevator", elevatorID, "remote", r.RemoteAddr)
+		return op.CancelOrder(elevatorID, be)
+	}))
+
+	mux.HandleFunc("/api/service", post(func(r *http.Request) error {
+		elevatorID, err := intValue(r, "elevator")
+		if err != nil {
+			return err
+		}
+		inService, err := strconv.ParseBool(r.FormValue("inService"))
+		if err != nil {
+			return fmt.Errorf("invalid inService %q", r.FormValue("inService"))
+		}
+		log.Info("operator service mode", "elevator", elevatorID, "inService", inService, "remote", r.RemoteAddr)
+		return op.SetInService(elevatorID, inService)
+	}))
+
+	mux.HandleFunc("/api/master", post(func(r *http.Request) error {
+		elevatorID, err := intValue(r, "elevator")
+		if err != nil {
+			return err
+		}
+		log.Info("operator master handover", "elevator", elevatorID, "remote", r.RemoteAddr)
+		return op.HandOverMaster(elevatorID)
+	}))
+
+	mux.HandleFunc("/api/drain", post(func(r *http.Request) error {
+		log.Info("operator drain", "remote", r.RemoteAddr)
+		return op.Drain()
+	}))
+
+	mux.HandleFunc("/api/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(op.State())
+	})
+
+	return mux
+}
+
+// post wraps a command so it only accepts POST and reports errors as 400.
+func post(command func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := command(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+func intValue(r *http.Request, name string) (int, error) {
+	v, err := strconv.Atoi(r.FormValue(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, r.FormValue(name))
+	}
+	return v, nil
+}
+
+func buttonEvent(r *http.Request) (drivers.ButtonEvent, error) {
+	floor, err := intValue(r, "floor")
+	if err != nil {
+		return drivers.ButtonEvent{}, err
+	}
+	var button drivers.ButtonType
+	switch r.FormValue("button") {
+	case "up":
+		button = drivers.BT_HallUp
+	case "down":
+		button = drivers.BT_HallDown
+	case "cab":
+		button = drivers.BT_Cab
+	default:
+		return drivers.ButtonEvent{}, fmt.Errorf("invalid button %q, use up, down or cab", r.FormValue("button"))
+	}
+	return drivers.ButtonEvent{Floor: floor, Button: button}, nil
+}
@@ -0,0 +1,101 @@
+package dashboard
+
+import (
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/network/peers"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//go:embed index.html
+var static embed.FS
+
+var log = logging.For("dashboard")
+
+// Snapshot is everything the dashboard shows about the cluster, as seen by
+// this node.
+type Snapshot struct {
+	NodeID                int            `json:"nodeID"`
+	MasterID              int            `json:"masterID"`
+	IsMaster              bool           `json:"isMaster"`
+	Term                  int            `json:"term"`
+	Protocol              int            `json:"protocol"` // The protocol version this node sends in
+	Peers                 []string       `json:"peers"`
+	Suspected             []string       `json:"suspected"` // Peers the failure detector suspects
+	Beacons               []peers.Beacon `json:"beacons"`   // The last beacon of each peer, by ID
+	HallRequests          [][2]bool      `json:"hallRequests"`
+	ConfirmedHallRequests [][2]bool      `json:"confirmedHallRequests"`
+	Elevators             []ElevatorView `json:"elevators"`
+}
+
+// ElevatorView is one elevator's row on the dashboard.
+type ElevatorView struct {
+	ID              int       `json:"id"`
+	State           string    `json:"state"`
+	Floor           int       `json:"floor"`
+	Direction       int       `json:"direction"`
+	TravelDirection int       `json:"travelDirection"`
+	HallRequests    [][2]bool `json:"hallRequests"`
+	CabRequests     []bool    `json:"cabRequests"`
+	ServedFloors    []bool    `json:"servedFloors"`
+	Draining        bool      `json:"draining"` // Keeps its hall orders but takes no new ones
+	LastUpdated     time.Time `json:"lastUpdated"`
+}
+
+// Handler serves the dashboard page on "/", the current snapshot as JSON on
+// "/state" and a stream of snapshots as server-sent events on "/events".
+func Handler(snapshot func() Snapshot, interval time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot())
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, snapshot, interval)
+	})
+	return mux
+}
+
+func serveEvents(w http.ResponseWriter, r *http.Request, snapshot func() Snapshot, interval time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	log.Debug("dashboard client connected", "remote", r.RemoteAddr)
+	defer log.Debug("dashboard client disconnected", "remote", r.RemoteAddr)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		data, err := json.Marshal(snapshot())
+		if err != nil {
+			log.Error("could not encode snapshot", "err", err)
+			return
+		}
+		// Only send when something changed, apart from a keepalive comment.
+		if string(data) != string(last) {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			last = data
+		} else {
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package drivers
 
 import (
-	"fmt"
+	"elevator-project/pkg/logging"
 	"net"
 	"sync"
 	"time"
@@ -13,6 +13,7 @@ var _initialized bool = false
 var _numFloors int = 4
 var _mtx sync.Mutex
 var _conn net.Conn
+var log = logging.For("drivers")
 
 type MotorDirection int
 
@@ -37,7 +38,7 @@ type ButtonEvent struct {
 
 func Init(addr string, numFloors int) {
 	if _initialized {
-		fmt.Println("Driver already initialized!")
+		log.Warn("driver already initialized")
 		return
 	}
 	_numFloors = numFloors
@@ -78,7 +79,7 @@ func PollButtons(receiver chan<- ButtonEvent) {
 			for b := ButtonType(0); b < 3; b++ {
 				v := GetButton(b, f)
 				if v != prev[f][b] && v != false {
-					fmt.Println("PollButtons detected button press: Floor", f, "Button", b)
+					log.Debug("button press", "floor", f, "button", b)
 					receiver <- ButtonEvent{Floor: f, Button: ButtonType(b)}
 				}
 				prev[f][b] = v
@@ -93,7 +94,7 @@ func PollFloorSensor(receiver chan<- int) {
 		time.Sleep(_pollRate)
 		v := GetFloor()
 		if v != prev && v != -1 {
-			fmt.Println("Floor detected: ", v)
+			log.Debug("floor detected", "floor", v)
 			receiver <- v
 		}
 		prev = v
@@ -0,0 +1,25 @@
+package drivers
+
+// Elevio is the output side of the elevator hardware, plus the floor sensor.
+// Hardware talks to the elevator server set up by Init; replay and simulation
+// provide their own.
+type Elevio interface {
+	SetMotorDirection(dir MotorDirection)
+	SetButtonLamp(button ButtonType, floor int, value bool)
+	SetFloorIndicator(floor int)
+	SetDoorOpenLamp(value bool)
+	SetStopLamp(value bool)
+	GetFloor() int
+}
+
+// Hardware is the elevator server connected with Init.
+type Hardware struct{}
+
+func (Hardware) SetMotorDirection(dir MotorDirection) { SetMotorDirection(dir) }
+func (Hardware) SetButtonLamp(button ButtonType, floor int, value bool) {
+	SetButtonLamp(button, floor, value)
+}
+func (Hardware) SetFloorIndicator(floor int) { SetFloorIndicator(floor) }
+func (Hardware) SetDoorOpenLamp(value bool)  { SetDoorOpenLamp(value) }
+func (Hardware) SetStopLamp(value bool)      { SetStopLamp(value) }
+func (Hardware) GetFloor() int               { return GetFloor() }
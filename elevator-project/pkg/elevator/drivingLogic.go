@@ -3,7 +3,6 @@ package elevator
 import (
 	"elevator-project/pkg/drivers"
 	"elevator-project/pkg/message"
-	"fmt"
 )
 
 func (e *Elevator) requestsAbove() bool {
@@ -96,89 +95,80 @@ func (e *Elevator) chooseDirection() Direction {
 }
 
 func (e *Elevator) clearHallReqsAtFloor() {
-	fmt.Println("Clearing orders from RequestMatrix")
+	log.Debug("clearing orders at floor", "floor", e.currentFloor, "direction", e.travelDirection)
 	switch e.travelDirection {
 	case Up:
 		if e.RequestMatrix.HallRequests[e.currentFloor][0] {
 			e.RequestMatrix.HallRequests[e.currentFloor][0] = false
 			completedOrderMsg := message.Message{
-				Type:        message.CompletedOrder,
-				ElevatorID:  e.ElevatorID,
-				MsgID:       e.counter.Next(),
-				ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallUp},
+				ElevatorID: e.ElevatorID,
+				MsgID:      e.counter.Next(),
+				Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallUp}},
 			}
-			fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_HallUp))
+			log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_HallUp)
 			e.msgTx <- completedOrderMsg
 		} else if e.RequestMatrix.HallRequests[e.currentFloor][1] {
 			e.RequestMatrix.HallRequests[e.currentFloor][1] = false
 			completedOrderMsg := message.Message{
-				Type:        message.CompletedOrder,
-				ElevatorID:  e.ElevatorID,
-				MsgID:       e.counter.Next(),
-				ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallDown},
+				ElevatorID: e.ElevatorID,
+				MsgID:      e.counter.Next(),
+				Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallDown}},
 			}
-			fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_HallDown))
+			log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_HallDown)
 			e.msgTx <- completedOrderMsg
 
 		}
 		if e.RequestMatrix.CabRequests[e.currentFloor] {
-			drivers.SetButtonLamp(drivers.BT_Cab, e.currentFloor, false)
 			e.RequestMatrix.CabRequests[e.currentFloor] = false
 			completedOrderMsg := message.Message{
-				Type:        message.CompletedOrder,
-				ElevatorID:  e.ElevatorID,
-				MsgID:       e.counter.Next(),
-				ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_Cab},
+				ElevatorID: e.ElevatorID,
+				MsgID:      e.counter.Next(),
+				Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_Cab}},
 			}
-			fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_Cab))
+			log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_Cab)
 			e.msgTx <- completedOrderMsg
 		}
 	case Down:
 		if e.RequestMatrix.HallRequests[e.currentFloor][1] {
 			e.RequestMatrix.HallRequests[e.currentFloor][1] = false
 			completedOrderMsg := message.Message{
-				Type:        message.CompletedOrder,
-				ElevatorID:  e.ElevatorID,
-				MsgID:       e.counter.Next(),
-				ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallDown},
+				ElevatorID: e.ElevatorID,
+				MsgID:      e.counter.Next(),
+				Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallDown}},
 			}
-			fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_HallDown))
+			log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_HallDown)
 			e.msgTx <- completedOrderMsg
 			//} else if !requestsBelow(rm, floor) {
 			//	clear(HallUp)
 		}
 		if e.RequestMatrix.CabRequests[e.currentFloor] {
-			drivers.SetButtonLamp(drivers.BT_Cab, e.currentFloor, false)
 			e.RequestMatrix.CabRequests[e.currentFloor] = false
 			completedOrderMsg := message.Message{
-				Type:        message.CompletedOrder,
-				ElevatorID:  e.ElevatorID,
-				MsgID:       e.counter.Next(),
-				ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_Cab},
+				ElevatorID: e.ElevatorID,
+				MsgID:      e.counter.Next(),
+				Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_Cab}},
 			}
-			fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_Cab))
+			log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_Cab)
 			e.msgTx <- completedOrderMsg
 		}
 	case Stop:
 		e.RequestMatrix.HallRequests[e.currentFloor][0] = false
 		completedOrderMsg1 := message.Message{
-			Type:        message.CompletedOrder,
-			ElevatorID:  e.ElevatorID,
-			MsgID:       e.counter.Next(),
-			ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallUp},
+			ElevatorID: e.ElevatorID,
+			MsgID:      e.counter.Next(),
+			Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallUp}},
 		}
-		fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_HallUp))
+		log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_HallUp)
 		e.msgTx <- completedOrderMsg1
 
 		//Add sleep?
 		e.RequestMatrix.HallRequests[e.currentFloor][1] = false
 		completedOrderMsg2 := message.Message{
-			Type:        message.CompletedOrder,
-			ElevatorID:  e.ElevatorID,
-			MsgID:       e.counter.Next(),
-			ButtonEvent: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallDown},
+			ElevatorID: e.ElevatorID,
+			MsgID:      e.counter.Next(),
+			Payload:    message.CompletedOrder{Event: drivers.ButtonEvent{Floor: e.currentFloor, Button: drivers.BT_HallDown}},
 		}
-		fmt.Printf("Clearing order: Floor: %d, Type: %d\n", e.currentFloor, int(drivers.BT_HallDown))
+		log.Info("clearing order", "floor", e.currentFloor, "button", drivers.BT_HallDown)
 		e.msgTx <- completedOrderMsg2
 	}
 }
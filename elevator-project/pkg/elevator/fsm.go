@@ -1,15 +1,27 @@
 package elevator
 
 import (
+	"context"
+	"elevator-project/pkg/clock"
 	"elevator-project/pkg/config"
 	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/eventlog"
+	"elevator-project/pkg/logging"
 	"elevator-project/pkg/message"
+	"elevator-project/pkg/metrics"
 	"elevator-project/pkg/orders"
+	"elevator-project/pkg/record"
 	"elevator-project/pkg/state"
-	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
 	"time"
 )
 
+var log = logging.For("fsm")
+
+var doorOpenSeconds = metrics.NewHistogram("elevator_door_open_seconds", "Time the door stays open, including obstructions.", metrics.DefaultBuckets)
+
 type ElevatorState int
 
 const (
@@ -21,6 +33,25 @@ const (
 	Error
 )
 
+func (s ElevatorState) String() string {
+	switch s {
+	case Idle:
+		return "Idle"
+	case MovingUp:
+		return "MovingUp"
+	case MovingDown:
+		return "MovingDown"
+	case DoorOpen:
+		return "DoorOpen"
+	case DoorObstructed:
+		return "DoorObstructed"
+	case Error:
+		return "Error"
+	default:
+		return "Unknown"
+	}
+}
+
 type FsmEvent int
 
 const (
@@ -46,23 +77,40 @@ type Elevator struct {
 	currentFloor    int
 	travelDirection Direction
 	RequestMatrix   *orders.RequestMatrix //should change the variable name to requestMatrix
+	servedFloors    []bool
+	inService       bool
 	Orders          chan drivers.ButtonEvent
+	cancels         chan drivers.ButtonEvent
+	serviceChanges  chan bool
 	fsmEvents       chan FsmEvent
-	doorTimer       *time.Timer
+	floorArrivals   chan int
+	halts           chan struct{}
+	halting         bool
+	halted          chan struct{} // Closed once the elevator has halted
+	doorTimer       clock.Timer
+	doorOpenedAt    time.Time
+	hw              drivers.Elevio
+	clk             clock.Clock
 	msgTx           chan message.Message
 	counter         *message.MsgID
+	log             *slog.Logger // Attaches the elevator ID
+	// The fields above are only used from Run. status is a copy of them,
+	// published by Run after every step for the other goroutines.
+	mu     sync.Mutex
+	status state.ElevatorStatus
 }
 
 func NewElevator(ElevatorID int, msgTx chan message.Message, counter *message.MsgID) *Elevator {
+	clk := clock.Real{}
 	drivers.SetMotorDirection(drivers.MD_Up)
 	foundFloorChan := make(chan int)
 
 	go func() {
-		ticker := time.NewTicker(10 * time.Millisecond)
+		ticker := clk.NewTicker(10 * time.Millisecond)
 		defer ticker.Stop()
 
 		for {
-			<-ticker.C
+			<-ticker.C()
 			currentFloor := drivers.GetFloor()
 			if currentFloor != -1 {
 				foundFloorChan <- currentFloor
@@ -74,56 +122,123 @@ func NewElevator(ElevatorID int, msgTx chan message.Message, counter *message.Ms
 
 	validFloor := <-foundFloorChan
 
-	return &Elevator{
+	return NewElevatorWith(ElevatorID, validFloor, drivers.Hardware{}, clk, msgTx, counter)
+}
+
+// NewElevatorWith creates an elevator standing still at startFloor that
+// drives hw and takes its time from clk. Replay and simulation use it with a
+// fake driver and a virtual clock.
+func NewElevatorWith(ElevatorID int, startFloor int, hw drivers.Elevio, clk clock.Clock, msgTx chan message.Message, counter *message.MsgID) *Elevator {
+	e := &Elevator{
 		ElevatorID:      ElevatorID,
+		log:             log.With("node", ElevatorID),
 		state:           Idle,
-		currentFloor:    validFloor,
+		currentFloor:    startFloor,
 		RequestMatrix:   orders.NewRequestMatrix(config.NumFloors),
+		servedFloors:    config.ServedFloorMask(ElevatorID),
+		inService:       true,
 		Orders:          make(chan drivers.ButtonEvent, 10),
+		cancels:         make(chan drivers.ButtonEvent, 10),
+		serviceChanges:  make(chan bool, 1),
 		fsmEvents:       make(chan FsmEvent, 10),
+		floorArrivals:   make(chan int, 10),
+		halts:           make(chan struct{}, 1),
+		halted:          make(chan struct{}),
+		hw:              hw,
+		clk:             clk,
 		msgTx:           msgTx,
 		counter:         counter,
 		travelDirection: Stop,
 	}
+	e.publish()
+	return e
 }
 
-func (e *Elevator) Run() {
-	for {
+// Run runs the elevator until ctx is done.
+func (e *Elevator) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if e.Step() {
+			continue
+		}
 		select {
-		case order := <-e.Orders:
-			e.handleNewOrder(order)
-		case ev := <-e.fsmEvents:
-			e.handleFSMEvent(ev)
-		case <-func() <-chan time.Time {
-			if e.doorTimer == nil {
-				return make(chan time.Time)
+		case <-e.clk.After(10 * time.Millisecond):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Step handles at most one pending input: an order, a cancellation, a service
+// change, a halt, an fsm event, a floor arrival or the door timer. With
+// nothing pending it updates the motor direction and returns false.
+func (e *Elevator) Step() bool {
+	defer e.publish()
+	var doorTimer <-chan time.Time
+	if e.doorTimer != nil {
+		doorTimer = e.doorTimer.C()
+	}
+
+	select {
+	case order := <-e.Orders:
+		e.handleNewOrder(order)
+	case order := <-e.cancels:
+		e.cancelOrder(order)
+	case inService := <-e.serviceChanges:
+		e.log.Info("service mode changed", "inService", inService)
+		e.inService = inService
+	case <-e.halts:
+		e.log.Info("halting at the next floor", "state", e.state, "floor", e.currentFloor)
+		e.halting = true
+	case ev := <-e.fsmEvents:
+		e.handleFSMEvent(ev)
+	case floor := <-e.floorArrivals:
+		e.arrivedAtFloor(floor)
+	case <-doorTimer:
+		e.doorTimer = nil
+		record.AddTimer(record.DoorTimer)
+		e.handleFSMEvent(EventDoorTimerElapsed)
+	default:
+		if e.halting {
+			if e.state == Idle && e.travelDirection == Stop {
+				select {
+				case <-e.halted:
+				default:
+					e.log.Info("halted", "floor", e.currentFloor)
+					close(e.halted)
+				}
 			}
-			return e.doorTimer.C
-		}():
-			e.fsmEvents <- EventDoorTimerElapsed
-		default:
-			if e.state == Idle || e.state == MovingUp || e.state == MovingDown {
-				newDirection := e.chooseDirection()
-				if newDirection != e.travelDirection {
-					switch newDirection {
-					case Up:
-						drivers.SetMotorDirection(drivers.MD_Up)
-					case Down:
-						drivers.SetMotorDirection(drivers.MD_Down)
-					case Stop:
-						drivers.SetMotorDirection(drivers.MD_Stop)
-					}
-
-					e.travelDirection = newDirection
+			return false
+		}
+		if e.state == Idle || e.state == MovingUp || e.state == MovingDown {
+			newDirection := e.chooseDirection()
+			if newDirection != e.travelDirection {
+				switch newDirection {
+				case Up:
+					e.hw.SetMotorDirection(drivers.MD_Up)
+				case Down:
+					e.hw.SetMotorDirection(drivers.MD_Down)
+				case Stop:
+					e.hw.SetMotorDirection(drivers.MD_Stop)
 				}
+
+				e.travelDirection = newDirection
 			}
-			time.Sleep(10 * time.Millisecond) //blocking -> should find a better solution
 		}
+		return false
 	}
+	return true
 }
 
 func (e *Elevator) handleNewOrder(order drivers.ButtonEvent) {
-	fmt.Printf("New order received type: %d, floor: %d\n", int(order.Button), order.Floor)
+	e.log.Info("new order", "floor", order.Floor, "button", order.Button)
+
+	if !e.ServesFloor(order.Floor) {
+		e.log.Warn("refusing order to unserved floor", "floor", order.Floor, "button", order.Button)
+		return
+	}
+	if !e.inService && order.Button != drivers.BT_Cab {
+		e.log.Warn("out of service, refusing hall order", "floor", order.Floor, "button", order.Button)
+		return
+	}
 
 	switch order.Button {
 	case drivers.BT_Cab:
@@ -139,10 +254,10 @@ func (e *Elevator) handleNewOrder(order drivers.ButtonEvent) {
 	if order.Floor == e.currentFloor && e.state == Idle ||
 		order.Floor == e.currentFloor && e.state == DoorOpen ||
 		order.Floor == e.currentFloor && e.state == DoorObstructed {
-		fmt.Printf("Received order on same floor. Ordertype: %d, floor: %d\n", int(order.Button), order.Floor)
+		e.log.Debug("order on current floor", "floor", order.Floor, "button", order.Button)
 		//drivers.SetButtonLamp(order.Button, order.Floor, false)
 		e.clearHallReqsAtFloor()
-		drivers.SetDoorOpenLamp(true)
+		e.hw.SetDoorOpenLamp(true)
 		e.transitionTo(DoorOpen)
 		return
 	}
@@ -151,17 +266,14 @@ func (e *Elevator) handleNewOrder(order drivers.ButtonEvent) {
 func (e *Elevator) handleFSMEvent(ev FsmEvent) {
 	switch ev {
 	case EventArrivedAtFloor:
-		e.currentFloor = drivers.GetFloor()
-		drivers.SetFloorIndicator(e.currentFloor)
-		if e.shouldStop() {
-			e.clearHallReqsAtFloor()
-			drivers.SetMotorDirection(drivers.MD_Stop)
-			drivers.SetDoorOpenLamp(true)
-			e.transitionTo(DoorOpen)
-		}
+		e.arrivedAtFloor(e.hw.GetFloor())
 	case EventDoorTimerElapsed:
-		if e.state == DoorOpen {
-			drivers.SetDoorOpenLamp(false)
+		if e.state == DoorOpen && e.halting {
+			e.hw.SetDoorOpenLamp(false)
+			e.travelDirection = Stop
+			e.transitionTo(Idle)
+		} else if e.state == DoorOpen {
+			e.hw.SetDoorOpenLamp(false)
 			newDirection := e.chooseDirection()
 			switch newDirection {
 			case Stop:
@@ -185,35 +297,56 @@ func (e *Elevator) handleFSMEvent(ev FsmEvent) {
 		}
 	case EventSetError:
 		e.transitionTo(Error)
-		drivers.SetMotorDirection(drivers.MD_Stop)
+		e.hw.SetMotorDirection(drivers.MD_Stop)
+	}
+}
+
+func (e *Elevator) arrivedAtFloor(floor int) {
+	e.currentFloor = floor
+	e.hw.SetFloorIndicator(e.currentFloor)
+	if e.halting || e.shouldStop() {
+		e.clearHallReqsAtFloor()
+		if e.halting {
+			e.travelDirection = Stop
+		}
+		e.hw.SetMotorDirection(drivers.MD_Stop)
+		e.hw.SetDoorOpenLamp(true)
+		e.transitionTo(DoorOpen)
 	}
 }
 
 func (e *Elevator) transitionTo(newState ElevatorState) {
+	e.log.Info("state transition", "from", e.state, "to", newState, "floor", e.currentFloor)
+	eventlog.Record(eventlog.Event{
+		Kind:     eventlog.FSMTransition,
+		Elevator: e.ElevatorID,
+		Floor:    e.currentFloor,
+		From:     e.state.String(),
+		To:       newState.String(),
+	})
+	wasDoorOpen := e.state == DoorOpen || e.state == DoorObstructed
+	isDoorOpen := newState == DoorOpen || newState == DoorObstructed
+	if !wasDoorOpen && isDoorOpen {
+		e.doorOpenedAt = e.clk.Now()
+	} else if wasDoorOpen && !isDoorOpen {
+		doorOpenSeconds.Observe(e.clk.Since(e.doorOpenedAt).Seconds())
+	}
 	e.state = newState
 	switch newState {
 	case Idle:
-		fmt.Println("[ElevatorFSM] State = Idle")
 	case DoorOpen:
-		fmt.Println("[ElevatorFSM] State = DoorOpen")
-		e.doorTimer = time.NewTimer(3 * time.Second)
+		e.doorTimer = e.clk.NewTimer(3 * time.Second)
 	case DoorObstructed:
 		if e.doorTimer != nil {
-			if !e.doorTimer.Stop() {
-				<-e.doorTimer.C
-			}
+			e.doorTimer.Stop()
 			e.doorTimer = nil
 		}
-		fmt.Println("[ElevatorFSM] State = DoorObstructed")
 	case MovingUp:
-		fmt.Println("[ElevatorFSM] State = MovingUp")
-		drivers.SetMotorDirection(drivers.MD_Up)
+		e.hw.SetMotorDirection(drivers.MD_Up)
 	case MovingDown:
-		fmt.Println("[ElevatorFSM] State = MovingDown")
-		drivers.SetMotorDirection(drivers.MD_Down)
+		e.hw.SetMotorDirection(drivers.MD_Down)
 	case Error:
-		fmt.Println("[ElevatorFSM] State = Error")
-		drivers.SetMotorDirection(drivers.MD_Stop)
+		e.hw.SetMotorDirection(drivers.MD_Stop)
 	}
 }
 
@@ -221,49 +354,118 @@ func (e *Elevator) UpdateElevatorState(ev FsmEvent) {
 	e.fsmEvents <- ev
 }
 
-func (e *Elevator) GetStatus() state.ElevatorStatus {
-	var reqMatrix orders.RequestMatrix
-	if e.RequestMatrix != nil {
-		reqMatrix = *e.RequestMatrix
-	}
-	return state.ElevatorStatus{
+// FloorReached tells the elevator that the floor sensor reports floor.
+func (e *Elevator) FloorReached(floor int) {
+	e.floorArrivals <- floor
+}
+
+// publish makes the state of the elevator the one GetStatus returns.
+func (e *Elevator) publish() {
+	status := state.ElevatorStatus{
 		ElevatorID:      e.ElevatorID,
 		State:           int(e.state), //cant export state, look into this later
 		CurrentFloor:    e.currentFloor,
 		TravelDirection: int(e.travelDirection),
-		LastUpdated:     time.Now(), // or use a stored timestamp if you maintain one
-		RequestMatrix:   reqMatrix,
+		RequestMatrix:   message.CopyRequestMatrix(*e.RequestMatrix),
+		ServedFloors:    e.servedFloors,
+		InService:       e.inService,
 	}
+	e.mu.Lock()
+	e.status = status
+	e.mu.Unlock()
 }
 
-func (e *Elevator) GetRequestMatrix() *orders.RequestMatrix {
-	return e.RequestMatrix
+// GetStatus returns the state of the elevator after its last step. It is
+// safe to call while Run runs, and shares no slices with the elevator.
+func (e *Elevator) GetStatus() state.ElevatorStatus {
+	e.mu.Lock()
+	status := e.status
+	e.mu.Unlock()
+	status.RequestMatrix = message.CopyRequestMatrix(status.RequestMatrix)
+	status.ServedFloors = slices.Clone(status.ServedFloors)
+	status.LastUpdated = e.clk.Now() // or use a stored timestamp if you maintain one
+	return status
 }
 
-func (e *Elevator) SetHallLigths(matrix [][2]bool) {
-	for i := 0; i < config.NumFloors-1; i++ {
-		drivers.SetButtonLamp(drivers.BT_HallUp, i, matrix[i][0])
-	}
-	for i := 1; i < config.NumFloors; i++ {
-		drivers.SetButtonLamp(drivers.BT_HallDown, i, matrix[i][1])
+// Halt makes the elevator stop at the next floor, let the passengers out and
+// stay there. It still takes orders, but does not move for them.
+func (e *Elevator) Halt() {
+	select {
+	case e.halts <- struct{}{}:
+	default:
 	}
 }
 
-// In package elevator
-func (e *Elevator) PrintRequestMatrix() {
-	fmt.Println("Request Matrix:")
+// Halted is closed once the elevator has halted.
+func (e *Elevator) Halted() <-chan struct{} {
+	return e.halted
+}
 
-	// Print Cab Requests
-	fmt.Println("Cab Requests:")
-	for floor, req := range e.RequestMatrix.CabRequests {
-		fmt.Printf("  Floor %d: %v\n", floor, req)
+// TryOrder queues an order for the elevator without blocking. It returns
+// false if the queue is full.
+func (e *Elevator) TryOrder(order drivers.ButtonEvent) bool {
+	select {
+	case e.Orders <- order:
+		return true
+	default:
+		return false
 	}
+}
 
-	// Print Hall Requests
-	fmt.Println("Hall Requests:")
-	for floor, hallReq := range e.RequestMatrix.HallRequests {
-		// Each hallReq is an array with two booleans:
-		// hallReq[0] for the "up" button and hallReq[1] for the "down" button.
-		fmt.Printf("  Floor %d: Up: %v, Down: %v\n", floor, hallReq[0], hallReq[1])
+// CancelOrder removes an order from the request matrix without serving it.
+func (e *Elevator) CancelOrder(order drivers.ButtonEvent) {
+	e.cancels <- order
+}
+
+// SetInService takes the elevator in or out of service. An elevator out of
+// service refuses new hall orders but still serves its cab calls.
+func (e *Elevator) SetInService(inService bool) {
+	e.serviceChanges <- inService
+}
+
+func (e *Elevator) cancelOrder(order drivers.ButtonEvent) {
+	if order.Floor < 0 || order.Floor >= len(e.RequestMatrix.CabRequests) {
+		return
 	}
+	e.log.Info("order cancelled", "floor", order.Floor, "button", order.Button)
+	switch order.Button {
+	case drivers.BT_Cab:
+		e.RequestMatrix.CabRequests[order.Floor] = false
+	case drivers.BT_HallUp:
+		e.RequestMatrix.HallRequests[order.Floor][0] = false
+	case drivers.BT_HallDown:
+		e.RequestMatrix.HallRequests[order.Floor][1] = false
+	}
+}
+
+// ServesFloor reports whether this elevator is configured to stop at floor.
+func (e *Elevator) ServesFloor(floor int) bool {
+	return floor >= 0 && floor < len(e.servedFloors) && e.servedFloors[floor]
+}
+
+func (e *Elevator) GetRequestMatrix() *orders.RequestMatrix {
+	return e.RequestMatrix
+}
+
+// CabRequests returns a copy of the cab requests accepted by this elevator,
+// as of its last step.
+func (e *Elevator) CabRequests() []bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.status.RequestMatrix.CabRequests)
+}
+
+// HallRequests returns a copy of the hall requests assigned to this
+// elevator, as of its last step.
+func (e *Elevator) HallRequests() [][2]bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.status.RequestMatrix.HallRequests)
+}
+
+// PrintRequestMatrix logs the request matrix at debug level.
+func (e *Elevator) PrintRequestMatrix() {
+	e.log.Debug("request matrix",
+		"cabRequests", e.RequestMatrix.CabRequests,
+		"hallRequests", e.RequestMatrix.HallRequests)
 }
@@ -0,0 +1,148 @@
+package eventlog
+
+import (
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/logging"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The event log is an append-only file of JSON lines, one per event, written
+// by every node. Unlike the console log it is meant to be read by tools: the
+// eventlog command merges the files of all nodes by time and can reconstruct
+// the history of a single order.
+
+type Kind string
+
+const (
+	ButtonPressed  Kind = "button_pressed"
+	OrderDelegated Kind = "order_delegated"
+	AckReceived    Kind = "ack_received"
+	FSMTransition  Kind = "fsm_transition"
+	OrderCompleted Kind = "order_completed"
+	OrderCancelled Kind = "order_cancelled"
+	ServiceChanged Kind = "service_changed"
+	PeerNew        Kind = "peer_new"
+	PeerLost       Kind = "peer_lost"
+	PeerSuspected  Kind = "peer_suspected"
+	PeerRecovered  Kind = "peer_recovered"
+	PeerRestarted  Kind = "peer_restarted"
+	MasterChanged  Kind = "master_changed"
+	NodeLeaving    Kind = "node_leaving"
+	NodeDraining   Kind = "node_draining"
+	HandoverDone   Kind = "handover_done"
+)
+
+type Event struct {
+	Time     time.Time `json:"time"`
+	Node     int       `json:"node"`
+	Seq      int       `json:"seq"` // Per node, orders events with equal timestamps
+	Kind     Kind      `json:"kind"`
+	Elevator int       `json:"elevator,omitempty"`
+	Floor    int       `json:"floor"`
+	Button   string    `json:"button,omitempty"`
+	MsgID    int       `json:"msgID,omitempty"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Peer     string    `json:"peer,omitempty"`
+	Master   int       `json:"master,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// OrderKey identifies the order an event is about, e.g. "hall-up-2" or
+// "cab-3-1" for a cab call to floor 1 in elevator 3. It is empty for events
+// that are not about an order.
+func (e Event) OrderKey() string {
+	switch e.Button {
+	case "":
+		return ""
+	case "cab":
+		return fmt.Sprintf("cab-%d-%d", e.Elevator, e.Floor)
+	default:
+		return fmt.Sprintf("hall-%s-%d", e.Button, e.Floor)
+	}
+}
+
+// ButtonName is the name used for a button in the event log.
+func ButtonName(b drivers.ButtonType) string {
+	switch b {
+	case drivers.BT_HallUp:
+		return "up"
+	case drivers.BT_HallDown:
+		return "down"
+	case drivers.BT_Cab:
+		return "cab"
+	default:
+		return "unknown"
+	}
+}
+
+// Order returns an event about the order be. elevatorID is the elevator the
+// order belongs to, and is needed to tell cab orders apart.
+func Order(kind Kind, elevatorID int, be drivers.ButtonEvent) Event {
+	return Event{Kind: kind, Elevator: elevatorID, Floor: be.Floor, Button: ButtonName(be.Button)}
+}
+
+var (
+	mu     sync.Mutex
+	file   *os.File
+	nodeID int
+	seq    int
+	log    = logging.For("eventlog")
+)
+
+// Open starts appending the events of this node to path. Until it is called
+// Record does nothing.
+func Open(path string, node int) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+	}
+	file = f
+	nodeID = node
+	seq = 0
+	return nil
+}
+
+// Close stops recording events.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	err := file.Close()
+	file = nil
+	return err
+}
+
+// Record timestamps e and appends it to the log.
+func Record(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return
+	}
+
+	e.Time = time.Now()
+	e.Node = nodeID
+	e.Seq = seq
+	seq++
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Error("could not encode event", "err", err, "kind", e.Kind)
+		return
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Error("could not write event", "err", err, "kind", e.Kind)
+	}
+}
@@ -0,0 +1,225 @@
+package failure
+
+import (
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/config"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Detector tells which peers are alive from the times their heartbeats
+// arrive, with the phi accrual method: it keeps the recent intervals between
+// the heartbeats of each peer, and phi is how unlikely it is, given their
+// mean and deviation, that the next heartbeat has not arrived yet. phi 1
+// means a 10% chance of a wrong suspicion, phi 2 a 1% chance and so on. A
+// peer whose heartbeats become irregular is given more time than one whose
+// heartbeats are like clockwork.
+
+// State is what a Detector thinks of a peer.
+type State int
+
+const (
+	Alive State = iota
+	Suspected
+	Dead
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspected:
+		return "suspected"
+	case Dead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// EventKind is a change of State.
+type EventKind string
+
+const (
+	PeerSuspected EventKind = "suspected" // Alive to Suspected
+	PeerDead      EventKind = "dead"      // Suspected to Dead
+	PeerRecovered EventKind = "recovered" // Suspected or Dead to Alive
+	// PeerRestarted is not decided by a Detector, but by peers when the
+	// beacons of a peer announce a new incarnation: the peer has lost its
+	// state, whether or not it was found dead in between.
+	PeerRestarted EventKind = "restarted"
+)
+
+// Event is a peer changing state.
+type Event struct {
+	Peer string    `json:"peer"`
+	Kind EventKind `json:"kind"`
+	Phi  float64   `json:"phi"`
+}
+
+// Config holds the thresholds of a Detector.
+type Config struct {
+	SuspectPhi      float64       // phi at which a peer is suspected
+	DeadPhi         float64       // phi at which a peer is dead
+	Window          int           // Number of heartbeat intervals kept per peer
+	MinStdDev       time.Duration // Lower bound of the interval deviation
+	AcceptablePause time.Duration // Added to the mean interval, for pauses such as GC
+	FirstInterval   time.Duration // Assumed interval until one has been seen
+}
+
+// DefaultConfig returns the thresholds in the config package.
+func DefaultConfig() Config {
+	return Config{
+		SuspectPhi:      config.FailureSuspectPhi,
+		DeadPhi:         config.FailureDeadPhi,
+		Window:          100,
+		MinStdDev:       config.FailureMinStdDev,
+		AcceptablePause: config.FailureAcceptablePause,
+		FirstInterval:   config.FailureFirstInterval,
+	}
+}
+
+// Detector is a phi accrual failure detector. It is safe for concurrent use.
+type Detector struct {
+	mu    sync.Mutex
+	cfg   Config
+	clk   clock.Clock
+	peers map[string]*history
+}
+
+// history is the heartbeat intervals of a peer, in a ring of cfg.Window.
+type history struct {
+	intervals []time.Duration
+	next      int
+	sum       float64
+	squares   float64
+	last      time.Time
+	state     State
+}
+
+// New creates a detector with time measured on clk.
+func New(cfg Config, clk clock.Clock) *Detector {
+	if cfg.Window < 1 {
+		cfg.Window = 1
+	}
+	return &Detector{cfg: cfg, clk: clk, peers: make(map[string]*history)}
+}
+
+// Heartbeat records a heartbeat from peer. It returns a PeerRecovered event
+// if the peer was suspected or dead.
+func (d *Detector) Heartbeat(peer string) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.clk.Now()
+	h, ok := d.peers[peer]
+	if !ok {
+		h = &history{}
+		h.add(d.cfg.FirstInterval, d.cfg.Window)
+		h.last = now
+		d.peers[peer] = h
+		return nil
+	}
+	var events []Event
+	if h.state != Alive {
+		events = append(events, Event{Peer: peer, Kind: PeerRecovered, Phi: d.phi(h, now)})
+	}
+	if h.state == Dead {
+		// The silence of a dead peer says nothing about its next interval.
+		*h = history{}
+		h.add(d.cfg.FirstInterval, d.cfg.Window)
+	} else {
+		h.add(now.Sub(h.last), d.cfg.Window)
+	}
+	h.state = Alive
+	h.last = now
+	return events
+}
+
+// Check returns the peers that have become suspected or dead since the last
+// Check, sorted by peer.
+func (d *Detector) Check() []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.clk.Now()
+	var events []Event
+	for peer, h := range d.peers {
+		phi := d.phi(h, now)
+		if h.state == Alive && phi >= d.cfg.SuspectPhi {
+			h.state = Suspected
+			events = append(events, Event{Peer: peer, Kind: PeerSuspected, Phi: phi})
+		}
+		if h.state == Suspected && phi >= d.cfg.DeadPhi {
+			h.state = Dead
+			events = append(events, Event{Peer: peer, Kind: PeerDead, Phi: phi})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Peer < events[j].Peer })
+	return events
+}
+
+// Phi returns the current phi of peer, or 0 if it has never been heard.
+func (d *Detector) Phi(peer string) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.peers[peer]
+	if !ok {
+		return 0
+	}
+	return d.phi(h, d.clk.Now())
+}
+
+// State returns the state of peer. A peer never heard is Dead.
+func (d *Detector) State(peer string) State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.peers[peer]
+	if !ok {
+		return Dead
+	}
+	return h.state
+}
+
+// Forget drops the history of peer.
+func (d *Detector) Forget(peer string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.peers, peer)
+}
+
+func (d *Detector) phi(h *history, now time.Time) float64 {
+	n := float64(len(h.intervals))
+	mean := h.sum / n
+	stdDev := math.Sqrt(math.Max(h.squares/n-mean*mean, 0))
+	stdDev = math.Max(stdDev, float64(d.cfg.MinStdDev))
+	mean += float64(d.cfg.AcceptablePause)
+	return phi(float64(now.Sub(h.last)), mean, stdDev)
+}
+
+func (h *history) add(interval time.Duration, window int) {
+	x := float64(interval)
+	if len(h.intervals) < window {
+		h.intervals = append(h.intervals, interval)
+	} else {
+		old := float64(h.intervals[h.next])
+		h.sum -= old
+		h.squares -= old * old
+		h.intervals[h.next] = interval
+		h.next = (h.next + 1) % window
+	}
+	h.sum += x
+	h.squares += x * x
+}
+
+// phi returns -log10 of the probability that a heartbeat comes later than
+// elapsed, with heartbeat intervals normally distributed with mean and
+// stdDev. The normal distribution is approximated with a logistic function,
+// which is cheaper and does not round to 1 as soon.
+func phi(elapsed, mean, stdDev float64) float64 {
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
@@ -0,0 +1,199 @@
+package failure
+
+import (
+	"elevator-project/pkg/clock"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	SuspectPhi:      1,
+	DeadPhi:         3,
+	Window:          4,
+	MinStdDev:       10 * time.Millisecond,
+	AcceptablePause: 0,
+	FirstInterval:   100 * time.Millisecond,
+}
+
+func newTestDetector() (*Detector, *clock.Virtual) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	return New(testConfig, clk), clk
+}
+
+// beat sends heartbeats from peer every interval, starting now.
+func beat(d *Detector, clk *clock.Virtual, peer string, n int, interval time.Duration) {
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			clk.Advance(interval)
+		}
+		d.Heartbeat(peer)
+	}
+}
+
+func TestPhi(t *testing.T) {
+	const mean, stdDev = 100.0, 10.0
+	for _, tc := range []struct {
+		deviations float64 // elapsed, in standard deviations past the mean
+		want       float64 // -log10 of the normal tail, which phi approximates
+	}{
+		{-3, 0.0006},
+		{0, 0.301},
+		{1, 0.800},
+		{2, 1.642},
+		{3, 2.870},
+		{4, 4.501},
+	} {
+		got := phi(mean+tc.deviations*stdDev, mean, stdDev)
+		if math.Abs(got-tc.want) > 0.1*tc.want+0.01 {
+			t.Errorf("%v deviations: phi %.3f, want %.3f", tc.deviations, got, tc.want)
+		}
+	}
+}
+
+func TestPhiThresholds(t *testing.T) {
+	// With heartbeats every 100ms, the deviation is MinStdDev: the peer is
+	// suspected at phi 1 just over a deviation late, and dead at phi 3 just
+	// over three.
+	for _, tc := range []struct {
+		silence time.Duration
+		want    State
+		events  []EventKind
+	}{
+		{100 * time.Millisecond, Alive, nil},
+		{110 * time.Millisecond, Alive, nil},
+		{115 * time.Millisecond, Suspected, []EventKind{PeerSuspected}},
+		{125 * time.Millisecond, Suspected, []EventKind{PeerSuspected}},
+		{135 * time.Millisecond, Dead, []EventKind{PeerSuspected, PeerDead}},
+		{time.Second, Dead, []EventKind{PeerSuspected, PeerDead}},
+	} {
+		d, clk := newTestDetector()
+		beat(d, clk, "1", 10, 100*time.Millisecond)
+		clk.Advance(tc.silence)
+		var kinds []EventKind
+		for _, e := range d.Check() {
+			kinds = append(kinds, e.Kind)
+		}
+		if got := d.State("1"); got != tc.want || !reflect.DeepEqual(kinds, tc.events) {
+			t.Errorf("after %v: %v with events %v, want %v with %v (phi %.2f)", tc.silence, got, kinds, tc.want, tc.events, d.Phi("1"))
+		}
+	}
+}
+
+func TestHistoryRing(t *testing.T) {
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name      string
+		intervals []time.Duration
+		window    int
+		kept      []time.Duration
+	}{
+		{"under window", []time.Duration{10 * ms, 20 * ms}, 4, []time.Duration{10 * ms, 20 * ms}},
+		{"full", []time.Duration{10 * ms, 20 * ms, 30 * ms}, 3, []time.Duration{10 * ms, 20 * ms, 30 * ms}},
+		{"wrapped", []time.Duration{10 * ms, 20 * ms, 30 * ms, 40 * ms, 50 * ms}, 3, []time.Duration{40 * ms, 50 * ms, 30 * ms}},
+		{"wrapped twice", []time.Duration{1 * ms, 2 * ms, 3 * ms, 4 * ms, 5 * ms, 6 * ms, 7 * ms}, 2, []time.Duration{7 * ms, 6 * ms}},
+		{"window of one", []time.Duration{10 * ms, 90 * ms}, 1, []time.Duration{90 * ms}},
+	} {
+		var h history
+		for _, x := range tc.intervals {
+			h.add(x, tc.window)
+		}
+		if !reflect.DeepEqual(h.intervals, tc.kept) {
+			t.Errorf("%s: kept %v, want %v", tc.name, h.intervals, tc.kept)
+			continue
+		}
+		var sum, squares float64
+		for _, x := range tc.kept {
+			sum += float64(x)
+			squares += float64(x) * float64(x)
+		}
+		n := float64(len(tc.kept))
+		mean, variance := sum/n, squares/n-(sum/n)*(sum/n)
+		gotMean, gotVariance := h.sum/n, h.squares/n-(h.sum/n)*(h.sum/n)
+		if math.Abs(gotMean-mean) > 1 || math.Abs(gotVariance-variance) > 1e-6*math.Max(variance, 1) {
+			t.Errorf("%s: mean %v variance %v, want %v and %v", tc.name, gotMean, gotVariance, mean, variance)
+		}
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	d, clk := newTestDetector()
+	if got := d.State("1"); got != Dead {
+		t.Fatalf("never heard: %v, want dead", got)
+	}
+	beat(d, clk, "1", 10, 100*time.Millisecond)
+	for _, step := range []struct {
+		advance   time.Duration
+		heartbeat bool
+		want      State
+		event     EventKind // "" for none
+	}{
+		{50 * time.Millisecond, false, Alive, ""},
+		{70 * time.Millisecond, false, Suspected, PeerSuspected},
+		{0, false, Suspected, ""},
+		{0, true, Alive, PeerRecovered},
+		{120 * time.Millisecond, false, Suspected, PeerSuspected},
+		{time.Second, false, Dead, PeerDead},
+		{time.Second, false, Dead, ""},
+		{0, true, Alive, PeerRecovered},
+	} {
+		clk.Advance(step.advance)
+		var events []Event
+		if step.heartbeat {
+			events = d.Heartbeat("1")
+		} else {
+			events = d.Check()
+		}
+		var kind EventKind
+		if len(events) > 1 || len(events) == 1 && events[0].Peer != "1" {
+			t.Fatalf("at %v: events %v", clk.Now().Sub(time.Unix(0, 0)), events)
+		}
+		if len(events) == 1 {
+			kind = events[0].Kind
+		}
+		if got := d.State("1"); got != step.want || kind != step.event {
+			t.Fatalf("at %v: %v with event %q, want %v with %q", clk.Now().Sub(time.Unix(0, 0)), got, kind, step.want, step.event)
+		}
+	}
+}
+
+func TestResetAfterDead(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		silence time.Duration
+		kept    int // intervals kept after the heartbeat ending the silence
+	}{
+		// A suspected peer's silence is a long interval, which makes the
+		// detector more patient with it.
+		{"suspected", 120 * time.Millisecond, 4},
+		// A dead peer starts over from FirstInterval.
+		{"dead", 10 * time.Second, 1},
+	} {
+		d, clk := newTestDetector()
+		beat(d, clk, "1", 10, 100*time.Millisecond)
+		clk.Advance(tc.silence)
+		d.Check()
+		d.Heartbeat("1")
+		if got := len(d.peers["1"].intervals); got != tc.kept {
+			t.Errorf("%s: %d intervals kept, want %d", tc.name, got, tc.kept)
+		}
+		// The next heartbeat on time keeps the peer alive either way.
+		clk.Advance(testConfig.FirstInterval)
+		if events := d.Check(); len(events) != 0 {
+			t.Errorf("%s: %v after a heartbeat on time", tc.name, events)
+		}
+	}
+	// After starting over, the silence of the dead peer is not counted: it is
+	// suspected as soon as one that never died.
+	d, clk := newTestDetector()
+	beat(d, clk, "1", 2, 100*time.Millisecond)
+	clk.Advance(10 * time.Second)
+	d.Check()
+	d.Heartbeat("1")
+	beat(d, clk, "2", 1, 0)
+	clk.Advance(150 * time.Millisecond)
+	if p1, p2 := d.Phi("1"), d.Phi("2"); p1 != p2 {
+		t.Errorf("phi %v after a restart from dead, %v for a new peer", p1, p2)
+	}
+}
@@ -0,0 +1,126 @@
+package lamps
+
+import (
+	"context"
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/drivers"
+	"sync"
+	"time"
+)
+
+// Controller owns the hall and cab button lamps of the local elevator. Nothing
+// else should set button lamps. The desired lamp state is derived
+// from the confirmed order state on every sync, and only the lamps that differ
+// from what was last written are rewritten. Because the desired state is
+// recomputed periodically, a lost packet can only leave a lamp wrong until the
+// order state itself converges.
+type Controller struct {
+	mu        sync.Mutex
+	numFloors int
+	hw        drivers.Elevio
+	hallLamps func() [][2]bool
+	cabLamps  func() []bool
+	written   [][3]bool
+	synced    bool
+	trigger   chan struct{}
+	clk       clock.Clock
+}
+
+// NewController creates a lamp controller for the lamps on hw. hallLamps
+// returns the confirmed hall requests and cabLamps the accepted cab requests
+// of this elevator.
+func NewController(numFloors int, hw drivers.Elevio, hallLamps func() [][2]bool, cabLamps func() []bool) *Controller {
+	return &Controller{
+		numFloors: numFloors,
+		hw:        hw,
+		hallLamps: hallLamps,
+		cabLamps:  cabLamps,
+		written:   make([][3]bool, numFloors),
+		trigger:   make(chan struct{}, 1),
+		clk:       clock.Real{},
+	}
+}
+
+// SetClock sets the clock driving Run. It must be called before Run.
+func (c *Controller) SetClock(clk clock.Clock) {
+	c.clk = clk
+}
+
+// Run syncs the lamps every interval, and immediately whenever Trigger is
+// called, until ctx is done.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	ticker := c.clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-c.trigger:
+		case <-ctx.Done():
+			return
+		}
+		c.Sync()
+	}
+}
+
+// Trigger requests a sync as soon as possible without waiting for the next
+// tick. It never blocks.
+func (c *Controller) Trigger() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Sync diffs the desired lamp state against the last written state and
+// rewrites the lamps that changed. The first sync writes every lamp so the
+// hardware starts from a known state.
+func (c *Controller) Sync() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	desired := c.desired()
+	for floor := 0; floor < c.numFloors; floor++ {
+		for btn := drivers.ButtonType(0); btn < 3; btn++ {
+			if !buttonExists(btn, floor, c.numFloors) {
+				continue
+			}
+			if c.synced && desired[floor][btn] == c.written[floor][btn] {
+				continue
+			}
+			c.hw.SetButtonLamp(btn, floor, desired[floor][btn])
+			c.written[floor][btn] = desired[floor][btn]
+		}
+	}
+	c.synced = true
+}
+
+func (c *Controller) desired() [][3]bool {
+	desired := make([][3]bool, c.numFloors)
+
+	hall := c.hallLamps()
+	for floor := 0; floor < c.numFloors && floor < len(hall); floor++ {
+		desired[floor][drivers.BT_HallUp] = hall[floor][0]
+		desired[floor][drivers.BT_HallDown] = hall[floor][1]
+	}
+
+	cab := c.cabLamps()
+	for floor := 0; floor < c.numFloors && floor < len(cab); floor++ {
+		desired[floor][drivers.BT_Cab] = cab[floor]
+	}
+
+	return desired
+}
+
+// buttonExists filters out hall up at the top floor and hall down at the
+// bottom floor, which the panel does not have.
+func buttonExists(btn drivers.ButtonType, floor int, numFloors int) bool {
+	switch btn {
+	case drivers.BT_HallUp:
+		return floor < numFloors-1
+	case drivers.BT_HallDown:
+		return floor > 0
+	default:
+		return true
+	}
+}
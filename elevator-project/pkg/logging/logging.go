@@ -0,0 +1,180 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Every subsystem gets its own logger with its own level, e.g.
+//
+//	var log = logging.For("fsm")
+//
+// All loggers write logfmt lines to the same output. A node attaches its ID
+// and master term with With, e.g.
+//
+//	log.With("node", id, "term", term)
+//
+// so the output of several nodes, in one process or several, can be merged
+// and grepped.
+
+var (
+	mu           sync.Mutex
+	levels       = make(map[string]*slog.LevelVar)
+	defaultLevel = slog.LevelInfo
+	output       = newOutput(os.Stdout)
+)
+
+// SetOutput redirects all loggers to w.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = newOutput(w)
+}
+
+// newOutput lets everything through; filtering is done per subsystem.
+func newOutput(w io.Writer) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+}
+
+// For returns the logger for a subsystem.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem, level: levelVar(subsystem)})
+}
+
+// SetLevel changes the level of a subsystem at runtime. The subsystem "*"
+// changes the default and every subsystem.
+func SetLevel(subsystem string, level slog.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if subsystem == "*" {
+		defaultLevel = level
+		for _, lv := range levels {
+			lv.Set(level)
+		}
+		return
+	}
+	lv, ok := levels[subsystem]
+	if !ok {
+		lv = new(slog.LevelVar)
+		levels[subsystem] = lv
+	}
+	lv.Set(level)
+}
+
+// SetLevels parses a level spec such as "info" or "debug,hra=warn,peers=error"
+// and applies it. A bare level applies to every subsystem.
+func SetLevels(spec string) error {
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		subsystem, levelName := "*", field
+		if i := strings.Index(field, "="); i >= 0 {
+			subsystem, levelName = field[:i], field[i+1:]
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelName)); err != nil {
+			return fmt.Errorf("invalid log level %q", levelName)
+		}
+		SetLevel(subsystem, level)
+	}
+	return nil
+}
+
+// Levels returns the current level of every known subsystem.
+func Levels() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out := map[string]string{"*": defaultLevel.String()}
+	for name, lv := range levels {
+		out[name] = lv.Level().String()
+	}
+	return out
+}
+
+// LevelHandler serves the log levels over HTTP. GET lists the levels and POST
+// applies the spec in the "levels" form value, e.g.
+//
+//	curl -d levels=debug,hra=warn localhost:8081/loglevel
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if err := SetLevels(r.FormValue("levels")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		current := Levels()
+		names := make([]string, 0, len(current))
+		for name := range current {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s=%s\n", name, current[name])
+		}
+	})
+}
+
+func levelVar(subsystem string) *slog.LevelVar {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lv, ok := levels[subsystem]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(defaultLevel)
+		levels[subsystem] = lv
+	}
+	return lv
+}
+
+func currentOutput() slog.Handler {
+	mu.Lock()
+	defer mu.Unlock()
+	return output
+}
+
+// handler filters on the subsystem level and adds the subsystem field before
+// passing the record on to the shared output. Attrs
+// and groups added with With/WithGroup are replayed on the output in order.
+type handler struct {
+	subsystem string
+	level     *slog.LevelVar
+	ops       []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := currentOutput().WithAttrs([]slog.Attr{slog.String("sys", h.subsystem)})
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := append(append([]func(slog.Handler) slog.Handler{}, h.ops...), op)
+	return &handler{subsystem: h.subsystem, level: h.level, ops: ops}
+}
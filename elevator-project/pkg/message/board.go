@@ -0,0 +1,154 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The hall request boards in Snapshot, OrderDelegation and Worldview grow
+// with the floors, and the assignments with the elevators too. From protocol
+// version 3, schema version 2 of these payload types encodes each board as a
+// string with a digit per floor, bit 0 for up and bit 1 for down, to keep
+// them within a datagram. Version 2 sends schema version 1, with a board as
+// an array of [up, down] pairs. Both decode.
+
+// board is a hall request board, [floor][up, down], in the compact encoding.
+type board [][2]bool
+
+func (b board) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	digits := make([]byte, len(b))
+	for floor, dirs := range b {
+		digits[floor] = '0'
+		if dirs[0] {
+			digits[floor] |= 1
+		}
+		if dirs[1] {
+			digits[floor] |= 2
+		}
+	}
+	return json.Marshal(string(digits))
+}
+
+func (b *board) UnmarshalJSON(data []byte) error {
+	var digits string
+	if err := json.Unmarshal(data, &digits); err != nil {
+		// Schema version 1
+		return json.Unmarshal(data, (*[][2]bool)(b))
+	}
+	*b = make(board, len(digits))
+	for floor, d := range []byte(digits) {
+		if d < '0' || d > '3' {
+			return fmt.Errorf("invalid board digit %q at floor %d", d, floor)
+		}
+		(*b)[floor] = [2]bool{(d-'0')&1 != 0, (d-'0')&2 != 0}
+	}
+	return nil
+}
+
+func boards(m map[string][][2]bool) map[string]board {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]board, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func unboards(m map[string]board) map[string][][2]bool {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string][][2]bool, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// A widened payload type has a schema version 1 encoding for protocol
+// version 2, returned by wide.
+type widened interface {
+	wide() any
+}
+
+type (
+	orderDelegationV1 OrderDelegation
+	worldviewV1       Worldview
+	snapshotV1        Snapshot
+)
+
+func (p OrderDelegation) wide() any { return orderDelegationV1(p) }
+func (p Worldview) wide() any       { return worldviewV1(p) }
+func (p Snapshot) wide() any        { return snapshotV1(p) }
+
+type compactOrderDelegation struct {
+	AckID  int              `json:"ackID"`
+	Orders map[string]board `json:"orders"`
+}
+
+func (p OrderDelegation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compactOrderDelegation{AckID: p.AckID, Orders: boards(p.Orders)})
+}
+
+func (p *OrderDelegation) UnmarshalJSON(data []byte) error {
+	var c compactOrderDelegation
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	*p = OrderDelegation{AckID: c.AckID, Orders: unboards(c.Orders)}
+	return nil
+}
+
+type compactWorldview struct {
+	AckID        int           `json:"ackID"`
+	Status       ElevatorState `json:"status"`
+	HallRequests board         `json:"hallRequests"`
+}
+
+func (p Worldview) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compactWorldview{AckID: p.AckID, Status: p.Status, HallRequests: p.HallRequests})
+}
+
+func (p *Worldview) UnmarshalJSON(data []byte) error {
+	var c compactWorldview
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	*p = Worldview{AckID: c.AckID, Status: c.Status, HallRequests: c.HallRequests}
+	return nil
+}
+
+type compactSnapshot struct {
+	Term         int              `json:"term"`
+	HallRequests board            `json:"hallRequests"`
+	Assignment   map[string]board `json:"assignment"`
+	HallLamps    board            `json:"hallLamps"`
+}
+
+func (p Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compactSnapshot{
+		Term:         p.Term,
+		HallRequests: p.HallRequests,
+		Assignment:   boards(p.Assignment),
+		HallLamps:    p.HallLamps,
+	})
+}
+
+func (p *Snapshot) UnmarshalJSON(data []byte) error {
+	var c compactSnapshot
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	*p = Snapshot{
+		Term:         c.Term,
+		HallRequests: c.HallRequests,
+		Assignment:   unboards(c.Assignment),
+		HallLamps:    c.HallLamps,
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"elevator-project/pkg/orders"
+	"slices"
+)
+
+// ElevatorStateDelta holds the fields of an ElevatorState that changed from
+// one worldview version to the next. Unchanged fields are left nil.
+type ElevatorStateDelta struct {
+	State           *int                  `json:"state,omitempty"`
+	Direction       *int                  `json:"direction,omitempty"`
+	CurrentFloor    *int                  `json:"currentFloor,omitempty"`
+	TravelDirection *int                  `json:"travelDirection,omitempty"`
+	RequestMatrix   *orders.RequestMatrix `json:"requestMatrix,omitempty"`
+	ServedFloors    []bool                `json:"servedFloors,omitempty"`
+	InService       *bool                 `json:"inService,omitempty"`
+}
+
+// Diff returns the changes from old to new. LastUpdated is not compared.
+func Diff(old, new ElevatorState) ElevatorStateDelta {
+	var d ElevatorStateDelta
+	if old.State != new.State {
+		d.State = &new.State
+	}
+	if old.Direction != new.Direction {
+		d.Direction = &new.Direction
+	}
+	if old.CurrentFloor != new.CurrentFloor {
+		d.CurrentFloor = &new.CurrentFloor
+	}
+	if old.TravelDirection != new.TravelDirection {
+		d.TravelDirection = &new.TravelDirection
+	}
+	if !slices.Equal(old.RequestMatrix.HallRequests, new.RequestMatrix.HallRequests) ||
+		!slices.Equal(old.RequestMatrix.CabRequests, new.RequestMatrix.CabRequests) {
+		rm := CopyRequestMatrix(new.RequestMatrix)
+		d.RequestMatrix = &rm
+	}
+	if !slices.Equal(old.ServedFloors, new.ServedFloors) {
+		d.ServedFloors = slices.Clone(new.ServedFloors)
+	}
+	if old.InService != new.InService {
+		d.InService = &new.InService
+	}
+	return d
+}
+
+// Empty reports whether nothing changed.
+func (d ElevatorStateDelta) Empty() bool {
+	return d.State == nil && d.Direction == nil && d.CurrentFloor == nil && d.TravelDirection == nil &&
+		d.RequestMatrix == nil && d.ServedFloors == nil && d.InService == nil
+}
+
+// Apply applies the changes to s.
+func (d ElevatorStateDelta) Apply(s *ElevatorState) {
+	if d.State != nil {
+		s.State = *d.State
+	}
+	if d.Direction != nil {
+		s.Direction = *d.Direction
+	}
+	if d.CurrentFloor != nil {
+		s.CurrentFloor = *d.CurrentFloor
+	}
+	if d.TravelDirection != nil {
+		s.TravelDirection = *d.TravelDirection
+	}
+	if d.RequestMatrix != nil {
+		s.RequestMatrix = CopyRequestMatrix(*d.RequestMatrix)
+	}
+	if d.ServedFloors != nil {
+		s.ServedFloors = slices.Clone(d.ServedFloors)
+	}
+	if d.InService != nil {
+		s.InService = *d.InService
+	}
+}
+
+// CopyRequestMatrix returns a copy of rm that shares no slices with it.
+func CopyRequestMatrix(rm orders.RequestMatrix) orders.RequestMatrix {
+	return orders.RequestMatrix{
+		HallRequests: slices.Clone(rm.HallRequests),
+		CabRequests:  slices.Clone(rm.CabRequests),
+	}
+}
@@ -0,0 +1,58 @@
+package message
+
+import (
+	"elevator-project/pkg/orders"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Largest returns the longest message of each payload type that grows with
+// the number of floors or elevators, in protocol version protocol, for
+// checking that they fit in a datagram.
+func Largest(floors, elevators, protocol int) []Message {
+	full := func() [][2]bool {
+		b := make([][2]bool, floors)
+		for floor := range b {
+			b[floor] = [2]bool{true, true}
+		}
+		return b
+	}
+	all := func() []bool {
+		set := make([]bool, floors)
+		for floor := range set {
+			set[floor] = true
+		}
+		return set
+	}
+	assignment := make(map[string][][2]bool, elevators)
+	for id := 1; id <= elevators; id++ {
+		assignment[strconv.Itoa(id)] = full()
+	}
+	status := ElevatorState{
+		ElevatorID:      elevators,
+		State:           math.MaxInt32,
+		Direction:       -1,
+		CurrentFloor:    floors - 1,
+		TravelDirection: -1,
+		LastUpdated:     time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.FixedZone("", -12*60*60)),
+		RequestMatrix:   orders.RequestMatrix{HallRequests: full(), CabRequests: all()},
+		ServedFloors:    all(),
+		InService:       true,
+	}
+	delta := Diff(ElevatorState{CurrentFloor: -1, InService: false}, status)
+
+	payloads := []Payload{
+		State{Version: math.MaxInt64, Status: status},
+		OrderDelegation{AckID: math.MaxInt64, Orders: assignment},
+		Worldview{AckID: math.MaxInt64, Status: status, HallRequests: full()},
+		Snapshot{Term: math.MaxInt64, HallRequests: full(), Assignment: assignment, HallLamps: full()},
+		WorldviewDelta{Version: math.MaxInt64, Delta: &delta},
+		CabCallRestore{TargetID: elevators, CabCalls: all()},
+	}
+	msgs := make([]Message, len(payloads))
+	for i, p := range payloads {
+		msgs[i] = Message{ElevatorID: elevators, MsgID: math.MaxInt64, Payload: p, Protocol: protocol}
+	}
+	return msgs
+}
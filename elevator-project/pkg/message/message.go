@@ -1,25 +1,77 @@
 package message
 
 import (
-	"elevator-project/pkg/drivers"
 	"elevator-project/pkg/orders"
 	"sync"
 	"time"
 )
 
+// MessageType tells the payload types apart on the wire. The values are sent,
+// so a type that is no longer used keeps its number.
 type MessageType int
 
 const (
-	State           MessageType = iota // Full worldview
-	ButtonEvent                        // All types of buttonpresses
-	OrderDelegation                    // Master delegates an order to a specific elevator
-	CompletedOrder
-	Ack
-	Heartbeat
-	MasterSlaveConfig // ???
-	Promotion         // Promotion msg letting other elevators know that a new elevator is master?
+	TypeState           MessageType = iota // Full worldview, the keyframe of version Version
+	TypeButtonEvent                        // All types of buttonpresses
+	TypeOrderDelegation                    // Master delegates an order to a specific elevator
+	TypeCompletedOrder
+	TypeAck
+	_                     // Heartbeat, no longer sent, every worldview broadcast is a heartbeat
+	TypeMasterSlaveConfig // Announces the master
+	TypePromotion         // The sender has become master and asks every node for its Worldview
+	TypeCancelOrder       // Operator cancels an order, TargetID is the elevator for cab orders
+	TypeServiceMode       // Operator takes elevator TargetID in or out of service
+	TypeDeparture         // The sender is shutting down, the master answers with an OrderDelegation acking it
+	TypeWorldview         // Answers the Promotion AckID with the sender's status and hall requests
+	TypeSnapshot          // The master's hall request board, assignments and lamps, sent every SnapshotInterval
+	TypeWorldviewDelta    // The changes from the previous worldview version to Version, if any
+	TypeKeyframeRequest   // Asks elevator TargetID for a State keyframe
+	TypeCabCallRestore    // The CabCalls elevator TargetID had before it restarted
+	TypeDrain             // The sender is draining, the master answers with an OrderDelegation acking it
 )
 
+// TypeUnknown is the type of a Message without a payload.
+const TypeUnknown MessageType = -1
+
+func (t MessageType) String() string {
+	switch t {
+	case TypeState:
+		return "State"
+	case TypeButtonEvent:
+		return "ButtonEvent"
+	case TypeOrderDelegation:
+		return "OrderDelegation"
+	case TypeCompletedOrder:
+		return "CompletedOrder"
+	case TypeAck:
+		return "Ack"
+	case TypeMasterSlaveConfig:
+		return "MasterSlaveConfig"
+	case TypePromotion:
+		return "Promotion"
+	case TypeCancelOrder:
+		return "CancelOrder"
+	case TypeServiceMode:
+		return "ServiceMode"
+	case TypeDeparture:
+		return "Departure"
+	case TypeWorldview:
+		return "Worldview"
+	case TypeSnapshot:
+		return "Snapshot"
+	case TypeWorldviewDelta:
+		return "WorldviewDelta"
+	case TypeKeyframeRequest:
+		return "KeyframeRequest"
+	case TypeCabCallRestore:
+		return "CabCallRestore"
+	case TypeDrain:
+		return "Drain"
+	default:
+		return "Unknown"
+	}
+}
+
 type ElevatorState struct {
 	ElevatorID      int
 	State           int
@@ -28,16 +80,33 @@ type ElevatorState struct {
 	TravelDirection int
 	LastUpdated     time.Time
 	RequestMatrix   orders.RequestMatrix
+	ServedFloors    []bool
+	InService       bool
 }
 
+// Message is the envelope of every message: the sender, its MsgID and one of
+// the payload types in payload.go. It is encoded with the type and schema
+// version of the payload, and decoding it validates the payload, see
+// registry.go.
 type Message struct {
-	Type        MessageType          `json:"type"`
-	ElevatorID  int                  `json:"elevatorID"`
-	MsgID       int                  `json:"msgID"`
-	StateData   *ElevatorState       `json:"stateData,omitempty"`
-	ButtonEvent drivers.ButtonEvent  `json:"buttonEvent,omitempty"`
-	OrderData   map[string][][2]bool `json:"orderData,omitempty"`
-	AckID       int                  `json:"ackID,omitempty"`
+	ElevatorID int
+	MsgID      int
+	Payload    Payload
+	// Protocol is the protocol version the message is encoded in, see
+	// protocol.go. 0 means ProtocolVersion. A decoded message has the
+	// version it arrived in.
+	Protocol int
+	// To lists the elevators a directed message is for. It only routes the
+	// message to the unicast transport and is not sent.
+	To []int
+}
+
+// Type returns the type of the payload, or TypeUnknown if there is none.
+func (m Message) Type() MessageType {
+	if m.Payload == nil {
+		return TypeUnknown
+	}
+	return m.Payload.Type()
 }
 
 type MsgID struct {
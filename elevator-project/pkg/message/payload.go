@@ -0,0 +1,278 @@
+package message
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"fmt"
+	"strconv"
+)
+
+// Payload is the content of a Message. There is one payload type per
+// MessageType, each registered in registry.go with its schema version.
+// Validate is called on every payload decoded from the network, so handlers
+// can rely on what it checks.
+type Payload interface {
+	Type() MessageType
+	Validate() error
+	dispatch(msg Message, h Handler)
+}
+
+// State is the full worldview of the sender, the keyframe of version Version.
+type State struct {
+	Version int           `json:"version"`
+	Status  ElevatorState `json:"status"`
+}
+
+// ButtonEvent is a button pressed on the panel of the sender.
+type ButtonEvent struct {
+	Event drivers.ButtonEvent `json:"event"`
+}
+
+// OrderDelegation is the hall orders the master assigns to each elevator, by
+// elevator ID, caused by message AckID of the receiver.
+type OrderDelegation struct {
+	AckID  int                  `json:"ackID"`
+	Orders map[string][][2]bool `json:"orders"`
+}
+
+// CompletedOrder is an order the sender's elevator has served.
+type CompletedOrder struct {
+	Event drivers.ButtonEvent `json:"event"`
+}
+
+// Ack acknowledges message AckID.
+type Ack struct {
+	AckID int `json:"ackID"`
+}
+
+// MasterSlaveConfig announces that elevator Master is the master from term
+// Term on. Term is 0 from nodes that do not count terms.
+type MasterSlaveConfig struct {
+	Master int `json:"master"`
+	Term   int `json:"term,omitempty"`
+}
+
+// Promotion announces that the sender has become master, and asks every node
+// for its Worldview.
+type Promotion struct{}
+
+// CancelOrder is an order cancelled by the operator. TargetID is the
+// elevator of a cab order.
+type CancelOrder struct {
+	Event    drivers.ButtonEvent `json:"event"`
+	TargetID int                 `json:"targetID,omitempty"`
+}
+
+// ServiceMode takes elevator TargetID in or out of service.
+type ServiceMode struct {
+	TargetID  int  `json:"targetID"`
+	InService bool `json:"inService"`
+}
+
+// Departure announces that the sender is shutting down. The master answers
+// with an OrderDelegation acking it.
+type Departure struct{}
+
+// Worldview answers Promotion AckID with the status of the sender's elevator
+// and its hall request board.
+type Worldview struct {
+	AckID        int           `json:"ackID"`
+	Status       ElevatorState `json:"status"`
+	HallRequests [][2]bool     `json:"hallRequests"`
+}
+
+// Snapshot is the master's hall request board, the assignment of the hall
+// orders on it and the hall lamps, in term Term.
+type Snapshot struct {
+	Term         int                  `json:"term"`
+	HallRequests [][2]bool            `json:"hallRequests"`
+	Assignment   map[string][][2]bool `json:"assignment"`
+	HallLamps    [][2]bool            `json:"hallLamps"`
+}
+
+// WorldviewDelta is the change from the previous worldview version of the
+// sender to Version. Delta is nil when nothing changed, making it a
+// heartbeat.
+type WorldviewDelta struct {
+	Version int                 `json:"version"`
+	Delta   *ElevatorStateDelta `json:"delta,omitempty"`
+}
+
+// KeyframeRequest asks elevator TargetID for a State keyframe.
+type KeyframeRequest struct {
+	TargetID int `json:"targetID"`
+}
+
+// CabCallRestore is the cab calls elevator TargetID had before it restarted.
+type CabCallRestore struct {
+	TargetID int    `json:"targetID"`
+	CabCalls []bool `json:"cabCalls"`
+}
+
+// Drain announces that the sender is draining: it takes no new hall orders,
+// but keeps the ones it has. The master answers with an OrderDelegation acking
+// it.
+type Drain struct{}
+
+func (State) Type() MessageType             { return TypeState }
+func (ButtonEvent) Type() MessageType       { return TypeButtonEvent }
+func (OrderDelegation) Type() MessageType   { return TypeOrderDelegation }
+func (CompletedOrder) Type() MessageType    { return TypeCompletedOrder }
+func (Ack) Type() MessageType               { return TypeAck }
+func (MasterSlaveConfig) Type() MessageType { return TypeMasterSlaveConfig }
+func (Promotion) Type() MessageType         { return TypePromotion }
+func (CancelOrder) Type() MessageType       { return TypeCancelOrder }
+func (ServiceMode) Type() MessageType       { return TypeServiceMode }
+func (Departure) Type() MessageType         { return TypeDeparture }
+func (Worldview) Type() MessageType         { return TypeWorldview }
+func (Snapshot) Type() MessageType          { return TypeSnapshot }
+func (WorldviewDelta) Type() MessageType    { return TypeWorldviewDelta }
+func (KeyframeRequest) Type() MessageType   { return TypeKeyframeRequest }
+func (CabCallRestore) Type() MessageType    { return TypeCabCallRestore }
+func (Drain) Type() MessageType             { return TypeDrain }
+
+func (p State) Validate() error {
+	if p.Version < 0 {
+		return fmt.Errorf("negative version %d", p.Version)
+	}
+	return p.Status.validate()
+}
+
+func (p ButtonEvent) Validate() error    { return validEvent(p.Event) }
+func (p CompletedOrder) Validate() error { return validEvent(p.Event) }
+func (p Ack) Validate() error            { return nil }
+func (p Promotion) Validate() error      { return nil }
+func (p Departure) Validate() error      { return nil }
+func (p Drain) Validate() error          { return nil }
+
+func (p OrderDelegation) Validate() error {
+	return validAssignment(p.Orders)
+}
+
+func (p MasterSlaveConfig) Validate() error {
+	if p.Term < 0 {
+		return fmt.Errorf("term %d", p.Term)
+	}
+	return validElevator(p.Master)
+}
+
+func (p CancelOrder) Validate() error {
+	if err := validEvent(p.Event); err != nil {
+		return err
+	}
+	if p.Event.Button == drivers.BT_Cab {
+		return validElevator(p.TargetID)
+	}
+	return nil
+}
+
+func (p ServiceMode) Validate() error {
+	return validElevator(p.TargetID)
+}
+
+func (p Worldview) Validate() error {
+	if err := p.Status.validate(); err != nil {
+		return err
+	}
+	return validFloors("hall requests", len(p.HallRequests))
+}
+
+func (p Snapshot) Validate() error {
+	if err := validFloors("hall requests", len(p.HallRequests)); err != nil {
+		return err
+	}
+	if err := validFloors("hall lamps", len(p.HallLamps)); err != nil {
+		return err
+	}
+	return validAssignment(p.Assignment)
+}
+
+func (p WorldviewDelta) Validate() error {
+	if p.Version < 0 {
+		return fmt.Errorf("negative version %d", p.Version)
+	}
+	if p.Delta == nil {
+		return nil
+	}
+	if rm := p.Delta.RequestMatrix; rm != nil {
+		if err := validFloors("hall requests", len(rm.HallRequests)); err != nil {
+			return err
+		}
+		if err := validFloors("cab requests", len(rm.CabRequests)); err != nil {
+			return err
+		}
+	}
+	if p.Delta.ServedFloors != nil {
+		return validFloors("served floors", len(p.Delta.ServedFloors))
+	}
+	return nil
+}
+
+func (p KeyframeRequest) Validate() error {
+	return validElevator(p.TargetID)
+}
+
+func (p CabCallRestore) Validate() error {
+	if err := validElevator(p.TargetID); err != nil {
+		return err
+	}
+	return validFloors("cab calls", len(p.CabCalls))
+}
+
+func (s ElevatorState) validate() error {
+	if err := validFloors("hall requests", len(s.RequestMatrix.HallRequests)); err != nil {
+		return err
+	}
+	if err := validFloors("cab requests", len(s.RequestMatrix.CabRequests)); err != nil {
+		return err
+	}
+	// Nodes before version 2 do not send the served floors, which means
+	// every floor is served.
+	if len(s.ServedFloors) == 0 {
+		return nil
+	}
+	return validFloors("served floors", len(s.ServedFloors))
+}
+
+func validEvent(be drivers.ButtonEvent) error {
+	if be.Floor < 0 || be.Floor >= config.NumFloors {
+		return fmt.Errorf("floor %d out of range", be.Floor)
+	}
+	if be.Button != drivers.BT_HallUp && be.Button != drivers.BT_HallDown && be.Button != drivers.BT_Cab {
+		return fmt.Errorf("unknown button %d", be.Button)
+	}
+	return nil
+}
+
+func validElevator(id int) error {
+	if id < 1 {
+		return fmt.Errorf("no elevator %d", id)
+	}
+	return nil
+}
+
+func validFloors(what string, floors int) error {
+	if floors != config.NumFloors {
+		return fmt.Errorf("%s for %d floors, want %d", what, floors, config.NumFloors)
+	}
+	return nil
+}
+
+func validAssignment(assignment map[string][][2]bool) error {
+	if assignment == nil {
+		return fmt.Errorf("no assignment")
+	}
+	for id, hall := range assignment {
+		elevatorID, err := strconv.Atoi(id)
+		if err != nil {
+			return fmt.Errorf("elevator %q is not a number", id)
+		}
+		if err := validElevator(elevatorID); err != nil {
+			return err
+		}
+		if err := validFloors("hall orders of elevator "+id, len(hall)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package message
+
+import (
+	"elevator-project/pkg/drivers"
+	"fmt"
+)
+
+// Every datagram carries the protocol version it is encoded in. Version 1 is
+// the Message of older nodes, one struct with the fields of every message
+// type and no version field. Version 2 is the envelope in registry.go with a
+// typed body. Version 3 encodes hall request boards compactly, see board.go.
+// A node can decode every version from MinProtocolVersion to
+// ProtocolVersion, and encodes a Message in the version in its Protocol
+// field.
+const (
+	MinProtocolVersion = 1
+	ProtocolVersion    = 3
+)
+
+// Speaks reports whether messages of protocol version can be decoded.
+func Speaks(version int) bool {
+	return version >= MinProtocolVersion && version <= ProtocolVersion
+}
+
+// legacyMessage is a Message in protocol version 1.
+type legacyMessage struct {
+	Type         MessageType          `json:"type"`
+	ElevatorID   int                  `json:"elevatorID"`
+	MsgID        int                  `json:"msgID"`
+	StateData    *legacyState         `json:"stateData,omitempty"`
+	ButtonEvent  drivers.ButtonEvent  `json:"buttonEvent,omitempty"`
+	OrderData    map[string][][2]bool `json:"orderData,omitempty"`
+	AckID        int                  `json:"ackID,omitempty"`
+	TargetID     int                  `json:"targetID,omitempty"`
+	InService    bool                 `json:"inService,omitempty"`
+	HallRequests [][2]bool            `json:"hallRequests,omitempty"`
+	HallLamps    [][2]bool            `json:"hallLamps,omitempty"`
+	Term         int                  `json:"term,omitempty"`
+	Version      int                  `json:"version,omitempty"`
+	Delta        *ElevatorStateDelta  `json:"delta,omitempty"`
+	CabCalls     []bool               `json:"cabCalls,omitempty"`
+}
+
+// legacyState is the ElevatorState of version 1. The first nodes did not
+// send InService, and were always in service.
+type legacyState struct {
+	ElevatorState
+	InService *bool
+}
+
+func toLegacyState(s ElevatorState) *legacyState {
+	return &legacyState{ElevatorState: s, InService: &s.InService}
+}
+
+func (s legacyState) state() ElevatorState {
+	status := s.ElevatorState
+	status.InService = s.InService == nil || *s.InService
+	return status
+}
+
+func toLegacy(m Message) (legacyMessage, error) {
+	l := legacyMessage{Type: m.Type(), ElevatorID: m.ElevatorID, MsgID: m.MsgID}
+	switch p := m.Payload.(type) {
+	case State:
+		l.Version = p.Version
+		l.StateData = toLegacyState(p.Status)
+	case ButtonEvent:
+		l.ButtonEvent = p.Event
+	case OrderDelegation:
+		l.AckID = p.AckID
+		l.OrderData = p.Orders
+	case CompletedOrder:
+		l.ButtonEvent = p.Event
+	case Ack:
+		l.AckID = p.AckID
+	case MasterSlaveConfig:
+		// The first nodes take the sender as the master.
+		l.ElevatorID = p.Master
+		l.TargetID = p.Master
+		l.Term = p.Term
+	case Promotion, Departure, Drain:
+	case CancelOrder:
+		l.ButtonEvent = p.Event
+		l.TargetID = p.TargetID
+	case ServiceMode:
+		l.TargetID = p.TargetID
+		l.InService = p.InService
+	case Worldview:
+		l.AckID = p.AckID
+		l.StateData = toLegacyState(p.Status)
+		l.HallRequests = p.HallRequests
+	case Snapshot:
+		l.Term = p.Term
+		l.HallRequests = p.HallRequests
+		l.OrderData = p.Assignment
+		l.HallLamps = p.HallLamps
+	case WorldviewDelta:
+		l.Version = p.Version
+		l.Delta = p.Delta
+	case KeyframeRequest:
+		l.TargetID = p.TargetID
+	case CabCallRestore:
+		l.TargetID = p.TargetID
+		l.CabCalls = p.CabCalls
+	default:
+		return l, fmt.Errorf("%s has no protocol version 1 encoding", m.Type())
+	}
+	return l, nil
+}
+
+func (l legacyMessage) payload() (Payload, error) {
+	switch l.Type {
+	case TypeState, TypeWorldview:
+		if l.StateData == nil {
+			return nil, fmt.Errorf("%s without state data", l.Type)
+		}
+		if l.Type == TypeState {
+			return State{Version: l.Version, Status: l.StateData.state()}, nil
+		}
+		return Worldview{AckID: l.AckID, Status: l.StateData.state(), HallRequests: l.HallRequests}, nil
+	case TypeButtonEvent:
+		return ButtonEvent{Event: l.ButtonEvent}, nil
+	case TypeOrderDelegation:
+		return OrderDelegation{AckID: l.AckID, Orders: l.OrderData}, nil
+	case TypeCompletedOrder:
+		return CompletedOrder{Event: l.ButtonEvent}, nil
+	case TypeAck:
+		return Ack{AckID: l.AckID}, nil
+	case TypeMasterSlaveConfig:
+		// Announced the sender itself when no target was set.
+		master := l.TargetID
+		if master == 0 {
+			master = l.ElevatorID
+		}
+		return MasterSlaveConfig{Master: master, Term: l.Term}, nil
+	case TypePromotion:
+		return Promotion{}, nil
+	case TypeCancelOrder:
+		return CancelOrder{Event: l.ButtonEvent, TargetID: l.TargetID}, nil
+	case TypeServiceMode:
+		return ServiceMode{TargetID: l.TargetID, InService: l.InService}, nil
+	case TypeDeparture:
+		return Departure{}, nil
+	case TypeDrain:
+		return Drain{}, nil
+	case TypeSnapshot:
+		return Snapshot{Term: l.Term, HallRequests: l.HallRequests, Assignment: l.OrderData, HallLamps: l.HallLamps}, nil
+	case TypeWorldviewDelta:
+		return WorldviewDelta{Version: l.Version, Delta: l.Delta}, nil
+	case TypeKeyframeRequest:
+		return KeyframeRequest{TargetID: l.TargetID}, nil
+	case TypeCabCallRestore:
+		return CabCallRestore{TargetID: l.TargetID, CabCalls: l.CabCalls}, nil
+	}
+	return nil, fmt.Errorf("unknown message type %d", l.Type)
+}
@@ -0,0 +1,240 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The registry holds the payload type and schema version of each
+// MessageType. A payload type gets a new schema version when a change to it
+// would be misread by nodes that only know the old one, such as a field
+// changing meaning. Nodes decode the schema versions they know and older ones,
+// and drop messages of newer ones.
+
+// ErrNoPayload is returned by Dispatch for a Message without a payload.
+var ErrNoPayload = errors.New("message has no payload")
+
+type registration struct {
+	schema int
+	decode func(data []byte) (Payload, error)
+}
+
+var registry = make(map[MessageType]registration)
+
+func register[P Payload](schema int) {
+	var zero P
+	registry[zero.Type()] = registration{
+		schema: schema,
+		decode: func(data []byte) (Payload, error) {
+			var p P
+			err := json.Unmarshal(data, &p)
+			return p, err
+		},
+	}
+}
+
+func init() {
+	register[State](1)
+	register[ButtonEvent](1)
+	register[OrderDelegation](2)
+	register[CompletedOrder](1)
+	register[Ack](1)
+	register[MasterSlaveConfig](1)
+	register[Promotion](1)
+	register[CancelOrder](1)
+	register[ServiceMode](1)
+	register[Departure](1)
+	register[Worldview](2)
+	register[Snapshot](2)
+	register[WorldviewDelta](1)
+	register[KeyframeRequest](1)
+	register[CabCallRestore](1)
+	register[Drain](1)
+}
+
+// Schema returns the schema version of the payload type of t.
+func Schema(t MessageType) (int, bool) {
+	r, ok := registry[t]
+	return r.schema, ok
+}
+
+// Handler handles every payload type. Implementing it makes the compiler
+// check that a handler covers a payload type added later.
+type Handler interface {
+	HandleState(msg Message, p State)
+	HandleButtonEvent(msg Message, p ButtonEvent)
+	HandleOrderDelegation(msg Message, p OrderDelegation)
+	HandleCompletedOrder(msg Message, p CompletedOrder)
+	HandleAck(msg Message, p Ack)
+	HandleMasterSlaveConfig(msg Message, p MasterSlaveConfig)
+	HandlePromotion(msg Message, p Promotion)
+	HandleCancelOrder(msg Message, p CancelOrder)
+	HandleServiceMode(msg Message, p ServiceMode)
+	HandleDeparture(msg Message, p Departure)
+	HandleWorldview(msg Message, p Worldview)
+	HandleSnapshot(msg Message, p Snapshot)
+	HandleWorldviewDelta(msg Message, p WorldviewDelta)
+	HandleKeyframeRequest(msg Message, p KeyframeRequest)
+	HandleCabCallRestore(msg Message, p CabCallRestore)
+	HandleDrain(msg Message, p Drain)
+}
+
+// Dispatch calls the method of h for the payload of msg.
+func Dispatch(msg Message, h Handler) error {
+	if msg.Payload == nil {
+		return ErrNoPayload
+	}
+	msg.Payload.dispatch(msg, h)
+	return nil
+}
+
+func (p State) dispatch(msg Message, h Handler)             { h.HandleState(msg, p) }
+func (p ButtonEvent) dispatch(msg Message, h Handler)       { h.HandleButtonEvent(msg, p) }
+func (p OrderDelegation) dispatch(msg Message, h Handler)   { h.HandleOrderDelegation(msg, p) }
+func (p CompletedOrder) dispatch(msg Message, h Handler)    { h.HandleCompletedOrder(msg, p) }
+func (p Ack) dispatch(msg Message, h Handler)               { h.HandleAck(msg, p) }
+func (p MasterSlaveConfig) dispatch(msg Message, h Handler) { h.HandleMasterSlaveConfig(msg, p) }
+func (p Promotion) dispatch(msg Message, h Handler)         { h.HandlePromotion(msg, p) }
+func (p CancelOrder) dispatch(msg Message, h Handler)       { h.HandleCancelOrder(msg, p) }
+func (p ServiceMode) dispatch(msg Message, h Handler)       { h.HandleServiceMode(msg, p) }
+func (p Departure) dispatch(msg Message, h Handler)         { h.HandleDeparture(msg, p) }
+func (p Worldview) dispatch(msg Message, h Handler)         { h.HandleWorldview(msg, p) }
+func (p Snapshot) dispatch(msg Message, h Handler)          { h.HandleSnapshot(msg, p) }
+func (p WorldviewDelta) dispatch(msg Message, h Handler)    { h.HandleWorldviewDelta(msg, p) }
+func (p KeyframeRequest) dispatch(msg Message, h Handler)   { h.HandleKeyframeRequest(msg, p) }
+func (p CabCallRestore) dispatch(msg Message, h Handler)    { h.HandleCabCallRestore(msg, p) }
+func (p Drain) dispatch(msg Message, h Handler)             { h.HandleDrain(msg, p) }
+
+// envelope is the encoding of a Message from protocol version 2.
+type envelope struct {
+	Protocol   int             `json:"protocol"`
+	Type       MessageType     `json:"type"`
+	Schema     int             `json:"schema"`
+	ElevatorID int             `json:"elevatorID"`
+	MsgID      int             `json:"msgID"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// MarshalJSON encodes m in protocol version m.Protocol.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if m.Payload == nil {
+		return nil, ErrNoPayload
+	}
+	switch m.Protocol {
+	case 0, 2, 3:
+	case 1:
+		l, err := toLegacy(m)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(l)
+	default:
+		return nil, fmt.Errorf("protocol version %d not spoken", m.Protocol)
+	}
+	r, ok := registry[m.Type()]
+	if !ok {
+		return nil, fmt.Errorf("unregistered message type %s", m.Type())
+	}
+	protocol, schema, body := ProtocolVersion, r.schema, any(m.Payload)
+	if m.Protocol == 2 {
+		protocol = 2
+		if w, ok := m.Payload.(widened); ok {
+			schema, body = 1, w.wide()
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{
+		Protocol:   protocol,
+		Type:       m.Type(),
+		Schema:     schema,
+		ElevatorID: m.ElevatorID,
+		MsgID:      m.MsgID,
+		Body:       data,
+	})
+}
+
+// UnmarshalJSON decodes a Message and validates its payload. It fails with a
+// DecodeError, e.g. for an unknown type or a newer schema version than the
+// registered one. A message
+// in a protocol version that is not spoken decodes to a Message without a
+// payload, with the sender, MsgID and version, for the receiver to reject.
+// An envelope without a protocol field, as sent before the field was added,
+// is version 2, the first with an envelope.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return &DecodeError{Reason: ReasonMalformed, Err: err}
+	}
+	if e.Protocol == 0 {
+		// Version 1 has no version field, nor a schema or body.
+		e.Protocol = 1
+		if e.Schema != 0 || e.Body != nil {
+			e.Protocol = 2
+		}
+	}
+	if !Speaks(e.Protocol) {
+		*m = Message{ElevatorID: e.ElevatorID, MsgID: e.MsgID, Protocol: e.Protocol}
+		return nil
+	}
+	if e.ElevatorID < 1 {
+		return &DecodeError{Protocol: e.Protocol, Reason: ReasonNoSender, Err: fmt.Errorf("%s from no elevator %d", e.Type, e.ElevatorID)}
+	}
+	var p Payload
+	var err error
+	if e.Protocol == 1 {
+		p, err = decodeLegacy(data)
+	} else {
+		p, err = decodeEnvelope(e)
+	}
+	if err == nil {
+		if err = p.Validate(); err != nil {
+			err = &DecodeError{Reason: ReasonInvalid, Err: fmt.Errorf("invalid %s: %w", e.Type, err)}
+		}
+	}
+	if err != nil {
+		var de *DecodeError
+		if errors.As(err, &de) {
+			de.ElevatorID, de.Protocol = e.ElevatorID, e.Protocol
+		}
+		return err
+	}
+	*m = Message{ElevatorID: e.ElevatorID, MsgID: e.MsgID, Payload: p, Protocol: e.Protocol}
+	return nil
+}
+
+func decodeLegacy(data []byte) (Payload, error) {
+	var l legacyMessage
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, &DecodeError{Reason: ReasonMalformed, Err: err}
+	}
+	if _, ok := registry[l.Type]; !ok {
+		return nil, &DecodeError{Reason: ReasonUnknownType, Err: fmt.Errorf("unknown message type %d", l.Type)}
+	}
+	p, err := l.payload()
+	if err != nil {
+		return nil, &DecodeError{Reason: ReasonNoBody, Err: err}
+	}
+	return p, nil
+}
+
+func decodeEnvelope(e envelope) (Payload, error) {
+	r, ok := registry[e.Type]
+	if !ok {
+		return nil, &DecodeError{Reason: ReasonUnknownType, Err: fmt.Errorf("unknown message type %d", e.Type)}
+	}
+	if e.Schema < 1 || e.Schema > r.schema {
+		return nil, &DecodeError{Reason: ReasonSchema, Err: fmt.Errorf("%s schema version %d not supported, have %d", e.Type, e.Schema, r.schema)}
+	}
+	if len(e.Body) == 0 || string(e.Body) == "null" {
+		return nil, &DecodeError{Reason: ReasonNoBody, Err: fmt.Errorf("%s without a body", e.Type)}
+	}
+	p, err := r.decode(e.Body)
+	if err != nil {
+		return nil, &DecodeError{Reason: ReasonMalformed, Err: fmt.Errorf("%s: %w", e.Type, err)}
+	}
+	return p, nil
+}
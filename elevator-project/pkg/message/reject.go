@@ -0,0 +1,76 @@
+package message
+
+import (
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/metrics"
+	"errors"
+	"strconv"
+)
+
+// A receiver drops the messages it cannot handle: those that do not decode,
+// do not validate, or are in a protocol version it does not speak. Each one
+// is counted by protocol version and reason, and logged once per sender and
+// reason, so a misbehaving or incompatible node does not flood the log.
+
+var log = logging.For("message")
+
+var rejected = metrics.NewCounterVec("elevator_incompatible_messages_total", "Received messages dropped as undecodable, invalid or in a protocol version not spoken, by version and reason.", "version", "reason")
+
+// Reasons a message is rejected for.
+const (
+	ReasonMalformed   = "malformed"    // Not a message at all
+	ReasonProtocol    = "protocol"     // A protocol version not spoken
+	ReasonNoSender    = "no_sender"    // No valid ElevatorID
+	ReasonUnknownType = "unknown_type" // A type not in the registry
+	ReasonSchema      = "schema"       // A schema version not known
+	ReasonNoBody      = "no_body"      // A missing or null body
+	ReasonInvalid     = "invalid"      // A payload that fails Validate
+)
+
+// A DecodeError is why a datagram is not a Message that can be handled, with
+// what is known of its sender.
+type DecodeError struct {
+	ElevatorID int // 0 if not known
+	Protocol   int // 0 if not known
+	Reason     string
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A Rejector drops messages for a receiver. It is not safe for concurrent
+// use; every receiver has its own.
+type Rejector struct {
+	logged map[rejection]bool
+}
+
+type rejection struct {
+	from   int
+	reason string
+}
+
+func NewRejector() *Rejector {
+	return &Rejector{logged: make(map[rejection]bool)}
+}
+
+// Reject counts a dropped message and logs it if it is the first from its
+// sender for its reason. An err that is not a DecodeError is malformed.
+func (r *Rejector) Reject(err error) {
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		de = &DecodeError{Reason: ReasonMalformed, Err: err}
+	}
+	rejected.Inc(strconv.Itoa(de.Protocol), de.Reason)
+	key := rejection{from: de.ElevatorID, reason: de.Reason}
+	if r.logged[key] {
+		return
+	}
+	r.logged[key] = true
+	log.Warn("dropping message", "from", de.ElevatorID, "protocol", de.Protocol, "reason", de.Reason, "err", de.Err)
+}
@@ -0,0 +1,322 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A small subset of the Prometheus client: counters, gauges and histograms,
+// optionally with labels, exposed in the text exposition format. Metrics are
+// registered in a registry when they are created, Default for those of the
+// process, e.g.
+//
+//	var hraSeconds = metrics.NewHistogram("hra_run_seconds", "...", metrics.DefaultBuckets)
+//
+// and a registry of its own for those of each node, so that the nodes of a
+// simulation are counted apart.
+
+// DefaultBuckets are the histogram buckets used for latencies, in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics by name.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// Default is the registry of the metrics of the process.
+var Default = NewRegistry()
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[name]; exists {
+		panic(fmt.Sprintf("metric %q registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// Handler serves the metrics of Default and of registries in the Prometheus
+// text format.
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Default.WriteAll(w)
+		for _, registry := range registries {
+			registry.WriteAll(w)
+		}
+	})
+}
+
+// WriteAll writes every metric of r to w, sorted by name.
+func (r *Registry) WriteAll(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// vec holds one value per combination of label values.
+type vec struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	values     map[string]float64
+	labels     map[string][]string
+}
+
+func (r *Registry) newVec(name, help, kind string, labelNames []string) *vec {
+	v := &vec{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+		labels:     make(map[string][]string),
+	}
+	r.register(name, v)
+	return v
+}
+
+func (v *vec) update(labelValues []string, f func(float64) float64) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %q expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.labels[key]; !ok {
+		v.labels[key] = append([]string{}, labelValues...)
+	}
+	v.values[key] = f(v.values[key])
+}
+
+func (v *vec) get(labelValues []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[strings.Join(labelValues, "\xff")]
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 && len(v.labelNames) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.name)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labelNames, v.labels[key]), formatValue(v.values[key]))
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ v *vec }
+
+// NewCounterVec creates a labelled counter and registers it in Default.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+// NewCounterVec creates a labelled counter and registers it in r.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.newVec(name, help, "counter", labelNames)}
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the counter with the given
+// label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.v.update(labelValues, func(old float64) float64 { return old + delta })
+}
+
+// Get returns the current value of the counter with the given label values.
+func (c *CounterVec) Get(labelValues ...string) float64 {
+	return c.v.get(labelValues)
+}
+
+// Counter is a counter without labels.
+type Counter struct{ c *CounterVec }
+
+// NewCounter creates a counter and registers it in Default.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounter creates a counter and registers it in r.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return &Counter{r.NewCounterVec(name, help)}
+}
+
+func (c *Counter) Inc()              { c.c.Inc() }
+func (c *Counter) Add(delta float64) { c.c.Add(delta) }
+func (c *Counter) Get() float64      { return c.c.Get() }
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ v *vec }
+
+// NewGaugeVec creates a labelled gauge and registers it in Default.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+// NewGaugeVec creates a labelled gauge and registers it in r.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.newVec(name, help, "gauge", labelNames)}
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add adds delta to the gauge with the given label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.update(labelValues, func(old float64) float64 { return old + delta })
+}
+
+// Get returns the current value of the gauge with the given label values.
+func (g *GaugeVec) Get(labelValues ...string) float64 {
+	return g.v.get(labelValues)
+}
+
+// Gauge is a gauge without labels.
+type Gauge struct{ g *GaugeVec }
+
+// NewGauge creates a gauge and registers it in Default.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewGauge creates a gauge and registers it in r.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return &Gauge{r.NewGaugeVec(name, help)}
+}
+
+func (g *Gauge) Set(value float64) { g.g.Set(value) }
+func (g *Gauge) Add(delta float64) { g.g.Add(delta) }
+func (g *Gauge) Get() float64      { return g.g.Get() }
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram creates a histogram with the given upper bounds, which must be
+// sorted in increasing order, and registers it in Default.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogram creates a histogram with the given upper bounds, which must be
+// sorted in increasing order, and registers it in r.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe adds a single observation.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return fmt.Sprintf("%g", v)
+	}
+}
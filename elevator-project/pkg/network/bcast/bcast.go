@@ -1,21 +1,42 @@
 package bcast
 
 import (
+	"context"
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/message"
 	"elevator-project/pkg/network/conn"
+	"elevator-project/pkg/network/faults"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"time"
 )
 
 const bufSize = 1024
 
+var log = logging.For("bcast")
+
 // Encodes received values from `chans` into type-tagged JSON, then broadcasts
-// it on `port`
-func Transmitter(port int, chans ...interface{}) {
+// it on `port`, or sends it to the multicast group if one is configured, until
+// ctx is done
+func Transmitter(ctx context.Context, port int, chans ...interface{}) {
+	pc, addr, err := conn.Dial(port)
+	if err != nil {
+		log.Error("could not open conn", "port", port, "err", err)
+		return
+	}
+	conn := faults.Wrap(pc)
+	defer conn.Close()
+	TransmitterOn(ctx, conn, addr, chans...)
+}
+
+// TransmitterOn is Transmitter on an existing conn, sending to addr
+func TransmitterOn(ctx context.Context, conn net.PacketConn, addr net.Addr, chans ...interface{}) {
 	checkArgs(chans...)
 	typeNames := make([]string, len(chans))
-	selectCases := make([]reflect.SelectCase, len(typeNames))
+	selectCases := make([]reflect.SelectCase, len(typeNames)+1)
 	for i, ch := range chans {
 		selectCases[i] = reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
@@ -23,56 +44,107 @@ func Transmitter(port int, chans ...interface{}) {
 		}
 		typeNames[i] = reflect.TypeOf(ch).Elem().String()
 	}
+	done := len(chans)
+	selectCases[done] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
 	for {
 		chosen, value, _ := reflect.Select(selectCases)
-		jsonstr, _ := json.Marshal(value.Interface())
-		ttj, _ := json.Marshal(typeTaggedJSON{
-			TypeId: typeNames[chosen],
-			JSON:   jsonstr,
-		})
-		if len(ttj) > bufSize {
-			panic(fmt.Sprintf(
-				"Tried to send a message longer than the buffer size (length: %d, buffer size: %d)\n\t'%s'\n"+
-					"Either send smaller packets, or go to network/bcast/bcast.go and increase the buffer size",
-				len(ttj), bufSize, string(ttj)))
+		if chosen == done {
+			return
+		}
+		ttj, err := encode(typeNames[chosen], value.Interface())
+		if err != nil {
+			log.Error("dropping value", "type", typeNames[chosen], "err", err)
+			continue
 		}
 		conn.WriteTo(ttj, addr)
+	}
+}
+
+// Fits returns an error if v is too long to be sent, which TransmitterOn
+// would drop.
+func Fits(v interface{}) error {
+	_, err := encode(reflect.TypeOf(v).String(), v)
+	return err
+}
 
+func encode(typeName string, v interface{}) ([]byte, error) {
+	jsonstr, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
 	}
+	ttj, err := json.Marshal(typeTaggedJSON{
+		TypeId: typeName,
+		JSON:   jsonstr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ttj) > bufSize {
+		return nil, fmt.Errorf("%d bytes encoded, longer than the buffer size %d", len(ttj), bufSize)
+	}
+	return ttj, nil
 }
 
 // Matches type-tagged JSON received on `port` to element types of `chans`, then
-// sends the decoded value on the corresponding channel
-func Receiver(port int, chans ...interface{}) {
+// sends the decoded value on the corresponding channel, until ctx is done
+func Receiver(ctx context.Context, port int, chans ...interface{}) {
+	pc, _, err := conn.Dial(port)
+	if err != nil {
+		log.Error("could not open conn", "port", port, "err", err)
+		return
+	}
+	conn := faults.Wrap(pc)
+	defer conn.Close()
+	ReceiverOn(ctx, conn, chans...)
+}
+
+// ReceiverOn is Receiver on an existing conn. It also returns when the conn
+// is closed
+func ReceiverOn(ctx context.Context, conn net.PacketConn, chans ...interface{}) {
 	checkArgs(chans...)
 	chansMap := make(map[string]interface{})
 	for _, ch := range chans {
 		chansMap[reflect.TypeOf(ch).Elem().String()] = ch
 	}
+	// Wake up a blocked ReadFrom when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
 
+	rejector := message.NewRejector()
 	var buf [bufSize]byte
-	conn := conn.DialBroadcastUDP(port)
 	for {
 		n, _, e := conn.ReadFrom(buf[0:])
+		if errors.Is(e, net.ErrClosed) || ctx.Err() != nil {
+			return
+		}
 		if e != nil {
-			fmt.Printf("bcast.Receiver(%d, ...):ReadFrom() failed: \"%+v\"\n", port, e)
+			log.Error("ReadFrom failed", "addr", conn.LocalAddr(), "err", e)
 		}
 
 		var ttj typeTaggedJSON
-		json.Unmarshal(buf[0:n], &ttj)
+		if err := json.Unmarshal(buf[0:n], &ttj); err != nil {
+			rejector.Reject(err)
+			continue
+		}
 		ch, ok := chansMap[ttj.TypeId]
 		if !ok {
 			continue
 		}
+		// A value that does not decode is rejected, rather than passed on
+		// half decoded.
 		v := reflect.New(reflect.TypeOf(ch).Elem())
-		json.Unmarshal(ttj.JSON, v.Interface())
+		if err := json.Unmarshal(ttj.JSON, v.Interface()); err != nil {
+			rejector.Reject(err)
+			continue
+		}
 		reflect.Select([]reflect.SelectCase{{
 			Dir:  reflect.SelectSend,
 			Chan: reflect.ValueOf(ch),
 			Send: reflect.Indirect(v),
+		}, {
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ctx.Done()),
 		}})
 	}
 }
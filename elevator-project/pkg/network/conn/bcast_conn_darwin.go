@@ -4,7 +4,6 @@
 package conn
 
 import (
-	"fmt"
 	"net"
 	"os"
 	"syscall"
@@ -13,29 +12,29 @@ import (
 func DialBroadcastUDP(port int) net.PacketConn {
 	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
 	if err != nil {
-		fmt.Println("Error: Socket:", err)
+		log.Error("Socket failed", "err", err)
 	}
 	syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
 	if err != nil {
-		fmt.Println("Error: SetSockOpt REUSEADDR:", err)
+		log.Error("SetSockOpt REUSEADDR failed", "err", err)
 	}
 	syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
 	if err != nil {
-		fmt.Println("Error: SetSockOpt BROADCAST:", err)
+		log.Error("SetSockOpt BROADCAST failed", "err", err)
 	}
 	syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
 	if err != nil {
-		fmt.Println("Error: SetSockOpt REUSEPORT:", err)
+		log.Error("SetSockOpt REUSEPORT failed", "err", err)
 	}
 	syscall.Bind(s, &syscall.SockaddrInet4{Port: port})
 	if err != nil {
-		fmt.Println("Error: Bind:", err)
+		log.Error("Bind failed", "err", err)
 	}
 
 	f := os.NewFile(uintptr(s), "")
 	conn, err := net.FilePacketConn(f)
 	if err != nil {
-		fmt.Println("Error: FilePacketConn:", err)
+		log.Error("FilePacketConn failed", "err", err)
 	}
 	f.Close()
 
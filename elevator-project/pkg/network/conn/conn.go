@@ -0,0 +1,5 @@
+package conn
+
+import "elevator-project/pkg/logging"
+
+var log = logging.For("conn")
@@ -0,0 +1,97 @@
+package conn
+
+import (
+	"elevator-project/pkg/config"
+	"fmt"
+	"net"
+)
+
+// Dial opens the conn broadcast messages and peer beacons are sent and
+// received on for port, and returns it with the address to send to: the
+// config.MulticastGroup if one is set, and the IPv4 limited broadcast address
+// otherwise.
+func Dial(port int) (net.PacketConn, net.Addr, error) {
+	if config.MulticastGroup == "" {
+		addr := &net.UDPAddr{IP: net.IPv4bcast, Port: port}
+		conn := DialBroadcastUDP(port)
+		if conn == nil {
+			return nil, nil, fmt.Errorf("could not open a broadcast conn on port %d", port)
+		}
+		return conn, addr, nil
+	}
+	group := net.ParseIP(config.MulticastGroup)
+	var ifi *net.Interface
+	if config.MulticastInterface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(config.MulticastInterface); err != nil {
+			return nil, nil, err
+		}
+	}
+	conn, addr, err := DialMulticastUDP(group, port, config.MulticastTTL, ifi)
+	if err != nil {
+		return nil, nil, fmt.Errorf("joining %s on port %d: %w", config.MulticastGroup, port, err)
+	}
+	return conn, addr, nil
+}
+
+// DialMulticastUDP opens a conn on port that has joined the IPv4 or IPv6
+// multicast group, and returns it with the address of the group. Datagrams
+// sent on it go at most ttl hops. ifi is the interface to join the group on
+// and send from, or nil for the system default. Unlike with
+// net.ListenMulticastUDP, the datagrams a host sends are looped back to it, so
+// several nodes can share a host.
+func DialMulticastUDP(group net.IP, port int, ttl int, ifi *net.Interface) (net.PacketConn, net.Addr, error) {
+	if group == nil || !group.IsMulticast() {
+		return nil, nil, fmt.Errorf("%v is not a multicast address", group)
+	}
+	network := "udp6"
+	if group.To4() != nil {
+		network = "udp4"
+	}
+	addr := &net.UDPAddr{IP: group, Port: port}
+	if ifi != nil && network == "udp6" && group.IsLinkLocalMulticast() {
+		addr.Zone = ifi.Name
+	}
+	conn, err := net.ListenMulticastUDP(network, ifi, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var outgoing net.IP
+	if ifi != nil && network == "udp4" {
+		if outgoing, err = interfaceIPv4(ifi); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = setMulticastOptions(fd, network == "udp6", ttl, ifi, outgoing)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, addr, nil
+}
+
+// interfaceIPv4 returns the first IPv4 address of ifi.
+func interfaceIPv4(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", ifi.Name)
+}
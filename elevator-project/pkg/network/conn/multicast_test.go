@@ -0,0 +1,75 @@
+package conn
+
+import (
+	"elevator-project/pkg/config"
+	"net"
+	"testing"
+	"time"
+)
+
+// loopback returns the loopback interface, if it can send multicast.
+func loopback(t *testing.T) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip("no interfaces:", err)
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			return &ifaces[i]
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+func TestDialMulticastLoopback(t *testing.T) {
+	for _, group := range []string{"239.255.42.99", "ff15::4299"} {
+		t.Run(group, func(t *testing.T) {
+			ifi := loopback(t)
+			c, addr, err := DialMulticastUDP(net.ParseIP(group), 0, 0, ifi)
+			if err != nil {
+				t.Skip("multicast not available:", err)
+			}
+			defer c.Close()
+			// Port 0 binds any free port; send to the one bound.
+			to := *addr.(*net.UDPAddr)
+			to.Port = c.LocalAddr().(*net.UDPAddr).Port
+			if _, err := c.WriteTo([]byte("hello"), &to); err != nil {
+				t.Skip("multicast not routable:", err)
+			}
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 16)
+			n, _, err := c.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("datagram not looped back: %v", err)
+			}
+			if got := string(buf[:n]); got != "hello" {
+				t.Fatalf("got %q, want %q", got, "hello")
+			}
+		})
+	}
+}
+
+func TestDialMulticastNotAGroup(t *testing.T) {
+	for _, group := range []net.IP{nil, net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1")} {
+		if c, _, err := DialMulticastUDP(group, 0, 1, nil); err == nil {
+			c.Close()
+			t.Errorf("%v: no error", group)
+		}
+	}
+}
+
+func TestDialUnknownInterface(t *testing.T) {
+	group, ifi := config.MulticastGroup, config.MulticastInterface
+	defer func() { config.MulticastGroup, config.MulticastInterface = group, ifi }()
+	config.MulticastGroup, config.MulticastInterface = "239.255.42.99", "no-such-interface0"
+
+	c, addr, err := Dial(0)
+	if err == nil {
+		c.Close()
+		t.Fatal("no error")
+	}
+	if c != nil || addr != nil {
+		t.Fatalf("got conn %v and addr %v with error %v", c, addr, err)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+package conn
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastOptions sets the hop limit of the multicast datagrams sent on
+// fd, and the interface they are sent from if ifi is not nil, and turns their
+// loopback back on. outgoing is the IPv4 address of ifi.
+func setMulticastOptions(fd uintptr, ipv6 bool, ttl int, ifi *net.Interface, outgoing net.IP) error {
+	s := syscall.Handle(fd)
+	if ipv6 {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, ttl); err != nil {
+			return err
+		}
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1); err != nil {
+			return err
+		}
+		if ifi != nil {
+			return syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifi.Index)
+		}
+		return nil
+	}
+	if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1); err != nil {
+		return err
+	}
+	if ifi != nil {
+		var addr [4]byte
+		copy(addr[:], outgoing.To4())
+		return syscall.SetsockoptInet4Addr(s, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package faults
+
+import (
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/metrics"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault injection for the UDP transports. Every socket of bcast and peers is
+// wrapped, and the faults are applied to the datagrams the node sends:
+//
+//	loss=20          drop 20% of the datagrams
+//	delay=50ms       delay every datagram
+//	jitter=20ms      spread the delay, see dist
+//	dist=normal      uniform (default), normal or exponential jitter
+//	reorder=5        hold 5% of the datagrams back by reorderDelay
+//	dup=2            send 2% of the datagrams twice
+//	partition=a      only talk to nodes in partition a
+//
+// Nodes only receive datagrams from nodes in the same partition. The default
+// partition is the empty one, so a node with partition=a is cut off from the
+// nodes without a partition until they join it or it leaves.
+
+var log = logging.For("faults")
+
+var faultsInjected = metrics.NewCounterVec("network_faults_total", "Datagrams affected by fault injection.", "fault")
+
+// reorderDelay is longer than the heartbeat and worldview intervals, so a
+// datagram held back arrives after the next one.
+const reorderDelay = 150 * time.Millisecond
+
+const maxPartitionLen = 32
+
+// A partitioned datagram starts with partitionMagic, the length of the
+// partition name and the name. Datagrams in the default partition are sent
+// unchanged. Neither bcast's JSON nor peers' ids start with a zero byte.
+const partitionMagic = 0x00
+
+type Config struct {
+	Loss         float64 // Percent of datagrams dropped
+	Delay        time.Duration
+	Jitter       time.Duration
+	Distribution string  // "uniform", "normal" or "exponential"
+	Reorder      float64 // Percent of datagrams held back by reorderDelay
+	Duplicate    float64 // Percent of datagrams sent twice
+	Partition    string
+}
+
+// Parse parses a spec such as "loss=20,delay=50ms,jitter=10ms,partition=a".
+// An empty spec or "off" means no faults.
+func Parse(spec string) (Config, error) {
+	var cfg Config
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return cfg, nil
+	}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("invalid fault %q, expected name=value", field)
+		}
+		var err error
+		switch name {
+		case "loss":
+			cfg.Loss, err = percent(value)
+		case "delay":
+			cfg.Delay, err = duration(value)
+		case "jitter":
+			cfg.Jitter, err = duration(value)
+		case "dist":
+			if value != "uniform" && value != "normal" && value != "exponential" {
+				err = fmt.Errorf("unknown distribution %q, use uniform, normal or exponential", value)
+			}
+			cfg.Distribution = value
+		case "reorder":
+			cfg.Reorder, err = percent(value)
+		case "dup":
+			cfg.Duplicate, err = percent(value)
+		case "partition":
+			if len(value) > maxPartitionLen {
+				err = fmt.Errorf("partition name longer than %d bytes", maxPartitionLen)
+			}
+			cfg.Partition = value
+		default:
+			err = fmt.Errorf("unknown fault %q", name)
+		}
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+func percent(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	return p, nil
+}
+
+func duration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// String formats the config as a spec Parse accepts.
+func (cfg Config) String() string {
+	var fields []string
+	if cfg.Loss > 0 {
+		fields = append(fields, fmt.Sprintf("loss=%g", cfg.Loss))
+	}
+	if cfg.Delay > 0 {
+		fields = append(fields, fmt.Sprintf("delay=%s", cfg.Delay))
+	}
+	if cfg.Jitter > 0 {
+		fields = append(fields, fmt.Sprintf("jitter=%s", cfg.Jitter))
+	}
+	if cfg.Distribution != "" {
+		fields = append(fields, "dist="+cfg.Distribution)
+	}
+	if cfg.Reorder > 0 {
+		fields = append(fields, fmt.Sprintf("reorder=%g", cfg.Reorder))
+	}
+	if cfg.Duplicate > 0 {
+		fields = append(fields, fmt.Sprintf("dup=%g", cfg.Duplicate))
+	}
+	if cfg.Partition != "" {
+		fields = append(fields, "partition="+cfg.Partition)
+	}
+	if len(fields) == 0 {
+		return "off"
+	}
+	return strings.Join(fields, ",")
+}
+
+// Injector holds the fault config shared by the sockets it has wrapped.
+type Injector struct {
+	mu  sync.Mutex
+	cfg Config
+	rnd *rand.Rand
+	clk clock.Clock
+}
+
+// Default is the injector of this node, used by Wrap and Handler.
+var Default = NewInjector(time.Now().UnixNano(), clock.Real{})
+
+// NewInjector creates an injector without faults. Delays are measured on clk.
+func NewInjector(seed int64, clk clock.Clock) *Injector {
+	return &Injector{rnd: rand.New(rand.NewSource(seed)), clk: clk}
+}
+
+func (inj *Injector) Set(cfg Config) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if cfg != inj.cfg {
+		log.Info("network faults changed", "faults", cfg.String())
+	}
+	inj.cfg = cfg
+}
+
+func (inj *Injector) Config() Config {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.cfg
+}
+
+// Wrap wraps c with the faults of the Default injector.
+func Wrap(c net.PacketConn) net.PacketConn {
+	return Default.Wrap(c)
+}
+
+// Wrap returns a conn that applies the injector's faults to c.
+func (inj *Injector) Wrap(c net.PacketConn) net.PacketConn {
+	return &faultyConn{PacketConn: c, inj: inj}
+}
+
+// plan decides the fate of one datagram: the delay of each copy to send, and
+// the partition to send it in.
+func (inj *Injector) plan() ([]time.Duration, string) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	cfg := inj.cfg
+
+	if cfg.Loss > 0 && inj.rnd.Float64()*100 < cfg.Loss {
+		faultsInjected.Inc("loss")
+		return nil, cfg.Partition
+	}
+	copies := 1
+	if cfg.Duplicate > 0 && inj.rnd.Float64()*100 < cfg.Duplicate {
+		faultsInjected.Inc("duplicate")
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = inj.delay(cfg)
+	}
+	if cfg.Reorder > 0 && inj.rnd.Float64()*100 < cfg.Reorder {
+		faultsInjected.Inc("reorder")
+		delays[0] += reorderDelay
+	}
+	return delays, cfg.Partition
+}
+
+func (inj *Injector) delay(cfg Config) time.Duration {
+	d := float64(cfg.Delay)
+	if cfg.Jitter > 0 {
+		switch cfg.Distribution {
+		case "normal":
+			d += inj.rnd.NormFloat64() * float64(cfg.Jitter)
+		case "exponential":
+			d += inj.rnd.ExpFloat64() * float64(cfg.Jitter)
+		default:
+			d += (2*inj.rnd.Float64() - 1) * float64(cfg.Jitter)
+		}
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+func (inj *Injector) partition() string {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.cfg.Partition
+}
+
+type faultyConn struct {
+	net.PacketConn
+	inj  *Injector
+	rbuf []byte
+}
+
+func (c *faultyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	delays, partition := c.inj.plan()
+
+	datagram := p
+	if partition != "" {
+		datagram = make([]byte, 0, 2+len(partition)+len(p))
+		datagram = append(datagram, partitionMagic, byte(len(partition)))
+		datagram = append(datagram, partition...)
+		datagram = append(datagram, p...)
+	} else if len(delays) > 0 && (len(delays) > 1 || delays[0] > 0) {
+		// The caller may reuse p once WriteTo returns.
+		datagram = append([]byte(nil), p...)
+	}
+
+	var err error
+	for _, d := range delays {
+		if d == 0 {
+			_, err = c.PacketConn.WriteTo(datagram, addr)
+			continue
+		}
+		faultsInjected.Inc("delay")
+		go func(d time.Duration) {
+			<-c.inj.clk.After(d)
+			c.PacketConn.WriteTo(datagram, addr)
+		}(d)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom returns the next datagram from the node's partition, and drops
+// the others.
+func (c *faultyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if len(c.rbuf) < len(p)+2+maxPartitionLen {
+		c.rbuf = make([]byte, len(p)+2+maxPartitionLen)
+	}
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(c.rbuf)
+		if err != nil {
+			return copy(p, c.rbuf[:n]), addr, err
+		}
+		partition, payload := "", c.rbuf[:n]
+		if n >= 2 && payload[0] == partitionMagic && n >= 2+int(payload[1]) {
+			partition = string(payload[2 : 2+payload[1]])
+			payload = payload[2+payload[1]:]
+		}
+		if partition != c.inj.partition() {
+			faultsInjected.Inc("partition")
+			continue
+		}
+		return copy(p, payload), addr, nil
+	}
+}
+
+// Handler serves the faults of the Default injector over HTTP. GET shows them
+// and POST replaces them with the spec in the "faults" form value, e.g.
+//
+//	curl -d faults=loss=20,delay=50ms localhost:8081/api/faults
+//	curl -d faults=off localhost:8081/api/faults
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			cfg, err := Parse(r.FormValue("faults"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			Default.Set(cfg)
+		}
+		fmt.Fprintln(w, Default.Config())
+	})
+}
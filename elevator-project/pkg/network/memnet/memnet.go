@@ -0,0 +1,188 @@
+package memnet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Network is an in-memory network for running several nodes in one process.
+// A datagram sent to Broadcast(port) is delivered to every conn on that port,
+// including the sender's, like UDP broadcast on a LAN. A datagram sent to the
+// Addr of a conn is delivered to that conn only. Like UDP, datagrams to a conn
+// that is not reading fast enough are dropped.
+type Network struct {
+	mu           sync.Mutex
+	conns        map[int][]*Conn // By port
+	disconnected map[int]bool    // By node
+}
+
+const queueSize = 256
+
+func New() *Network {
+	return &Network{
+		conns:        make(map[int][]*Conn),
+		disconnected: make(map[int]bool),
+	}
+}
+
+// Addr is the address of the conn of a node on a port. Node 0 is the
+// broadcast address.
+type Addr struct {
+	Node int
+	Port int
+}
+
+func (a Addr) Network() string { return "memnet" }
+func (a Addr) String() string  { return fmt.Sprintf("node%d:%d", a.Node, a.Port) }
+
+// Broadcast returns the broadcast address of port.
+func Broadcast(port int) Addr {
+	return Addr{Port: port}
+}
+
+// Listen opens a conn for node on port.
+func (nw *Network) Listen(node, port int) *Conn {
+	c := &Conn{
+		nw:     nw,
+		addr:   Addr{Node: node, Port: port},
+		queue:  make(chan datagram, queueSize),
+		closed: make(chan struct{}),
+	}
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.conns[port] = append(nw.conns[port], c)
+	return c
+}
+
+// SetConnected connects or disconnects node. A disconnected node neither
+// sends nor receives anything.
+func (nw *Network) SetConnected(node int, connected bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.disconnected[node] = !connected
+}
+
+func (nw *Network) Connected(node int) bool {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return !nw.disconnected[node]
+}
+
+func (nw *Network) send(from Addr, to Addr, p []byte) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.disconnected[from.Node] {
+		return
+	}
+	for _, c := range nw.conns[to.Port] {
+		if nw.disconnected[c.addr.Node] || (to.Node != 0 && to.Node != c.addr.Node) {
+			continue
+		}
+		select {
+		case c.queue <- datagram{data: append([]byte(nil), p...), from: from}:
+		default:
+		}
+	}
+}
+
+func (nw *Network) remove(c *Conn) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	conns := nw.conns[c.addr.Port]
+	for i, other := range conns {
+		if other == c {
+			nw.conns[c.addr.Port] = append(conns[:i:i], conns[i+1:]...)
+			return
+		}
+	}
+}
+
+type datagram struct {
+	data []byte
+	from Addr
+}
+
+// Conn is a net.PacketConn on a Network. Deadlines use the wall clock.
+type Conn struct {
+	nw        *Network
+	addr      Addr
+	queue     chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case dg := <-c.queue:
+		return copy(p, dg.data), dg.from, nil
+	case <-c.closed:
+		return 0, nil, c.opError("read", net.ErrClosed)
+	case <-timeout:
+		return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+	}
+}
+
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+	to, ok := addr.(Addr)
+	if !ok {
+		return 0, c.opError("write", fmt.Errorf("not a memnet address: %v", addr))
+	}
+	c.nw.send(c.addr, to, p)
+	return len(p), nil
+}
+
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.nw.remove(c)
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr { return c.addr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes never block.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "memnet", Addr: c.addr, Err: err}
+}
@@ -0,0 +1,70 @@
+package peers
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Roles of a node in a Beacon.
+const (
+	RoleMaster = "master"
+	RoleSlave  = "slave"
+)
+
+// Beacon is what a node announces about itself every interval.
+type Beacon struct {
+	ID          string `json:"id"`
+	Version     string `json:"version,omitempty"`     // Software version
+	Role        string `json:"role,omitempty"`        // RoleMaster or RoleSlave
+	Term        int    `json:"term,omitempty"`        // Of the master, the same on every node
+	Incarnation int64  `json:"incarnation,omitempty"` // Changes every time the node starts
+	InService   bool   `json:"inService"`
+	// Addrs are the addresses the node listens on, such as its unicast
+	// address. An unspecified host means the host the beacon comes from.
+	Addrs []string `json:"addrs,omitempty"`
+	// MinProtocol and MaxProtocol are the message protocol versions the
+	// node speaks.
+	MinProtocol int `json:"minProtocol,omitempty"`
+	MaxProtocol int `json:"maxProtocol,omitempty"`
+}
+
+// Protocols returns the message protocol versions b announces. Nodes from
+// before protocol versions speak version 1 only.
+func (b Beacon) Protocols() (min, max int) {
+	min, max = b.MinProtocol, b.MaxProtocol
+	if min == 0 {
+		min = 1
+	}
+	if max == 0 {
+		max = 1
+	}
+	return min, max
+}
+
+// Equal reports whether b and other announce the same.
+func (b Beacon) Equal(other Beacon) bool {
+	return b.ID == other.ID && b.Version == other.Version && b.Role == other.Role && b.Term == other.Term &&
+		b.Incarnation == other.Incarnation && b.InService == other.InService && slices.Equal(b.Addrs, other.Addrs) &&
+		b.MinProtocol == other.MinProtocol && b.MaxProtocol == other.MaxProtocol
+}
+
+func (b Beacon) encode() []byte {
+	data, _ := json.Marshal(b)
+	return data
+}
+
+// decodeBeacon decodes a beacon. A datagram that is not JSON is the bare ID
+// sent by older nodes.
+func decodeBeacon(data []byte) (Beacon, bool) {
+	if len(data) == 0 {
+		return Beacon{}, false
+	}
+	if data[0] != '{' {
+		return Beacon{ID: string(data), InService: true}, true
+	}
+	var b Beacon
+	if err := json.Unmarshal(data, &b); err != nil || b.ID == "" {
+		return Beacon{}, false
+	}
+	return b, true
+}
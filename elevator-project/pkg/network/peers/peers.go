@@ -1,8 +1,13 @@
 package peers
 
 import (
+	"context"
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/failure"
+	"elevator-project/pkg/logging"
 	"elevator-project/pkg/network/conn"
-	"fmt"
+	"elevator-project/pkg/network/faults"
+	"errors"
 	"net"
 	"sort"
 	"time"
@@ -12,76 +17,168 @@ type PeerUpdate struct {
 	Peers []string
 	New   string
 	Lost  []string
+	Addrs map[string]net.Addr // Where the beacons of each peer come from
+	// Beacons holds the last beacon of each peer. A changed beacon makes an
+	// update.
+	Beacons map[string]Beacon
+	// Suspected lists the peers the failure detector suspects, which are
+	// still in Peers. Events holds the failure detector events since the
+	// last update; a peer is Lost when it is dead. A peer announcing a new
+	// incarnation makes a failure.PeerRestarted event.
+	Suspected []string
+	Events    []failure.Event
 }
 
 const interval = 15 * time.Millisecond
-const timeout = 500 * time.Millisecond
 
-func Transmitter(port int, id string, transmitEnable <-chan bool) {
+var clk clock.Clock = clock.Real{}
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+var log = logging.For("peers")
 
+// SetClock sets the clock used for beacon intervals and failure detection. It must
+// be called before Transmitter and Receiver are started. Socket deadlines
+// always use the wall clock.
+func SetClock(c clock.Clock) {
+	clk = c
+}
+
+// Transmitter sends beacon on port every interval, replacing it with each
+// beacon on beaconUpdates, until ctx is done.
+func Transmitter(ctx context.Context, port int, beacon Beacon, beaconUpdates <-chan Beacon, transmitEnable <-chan bool) {
+
+	pc, addr, err := conn.Dial(port)
+	if err != nil {
+		log.Error("could not open conn", "port", port, "err", err)
+		return
+	}
+	conn := faults.Wrap(pc)
+	defer conn.Close()
+	TransmitterOn(ctx, conn, addr, beacon, beaconUpdates, transmitEnable, clk)
+}
+
+// TransmitterOn is Transmitter on an existing conn, sending to addr, with
+// beacon intervals measured on clk. It returns when ctx is done.
+func TransmitterOn(ctx context.Context, conn net.PacketConn, addr net.Addr, beacon Beacon, beaconUpdates <-chan Beacon, transmitEnable <-chan bool, clk clock.Clock) {
 	enable := true
+	data := beacon.encode()
 	for {
 		select {
 		case enable = <-transmitEnable:
-		case <-time.After(interval):
+		case beacon = <-beaconUpdates:
+			data = beacon.encode()
+		case <-clk.After(interval):
+		case <-ctx.Done():
+			return
 		}
 		if enable {
-			conn.WriteTo([]byte(id), addr)
+			conn.WriteTo(data, addr)
 		}
 	}
 }
 
-func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
+func Receiver(ctx context.Context, port int, peerUpdateCh chan<- PeerUpdate) {
+	pc, _, err := conn.Dial(port)
+	if err != nil {
+		log.Error("could not open conn", "port", port, "err", err)
+		return
+	}
+	conn := faults.Wrap(pc)
+	defer conn.Close()
+	ReceiverOn(ctx, conn, peerUpdateCh, failure.New(failure.DefaultConfig(), clk))
+}
+
+// ReceiverOn is Receiver on an existing conn, with the beacons of each peer
+// as heartbeats for det. It returns when ctx is done or the conn is closed.
+func ReceiverOn(ctx context.Context, conn net.PacketConn, peerUpdateCh chan<- PeerUpdate, det *failure.Detector) {
 
 	var buf [1024]byte
 	var p PeerUpdate
-	lastSeen := make(map[string]time.Time)
-
-	conn := conn.DialBroadcastUDP(port)
+	members := make(map[string]bool)
+	suspected := make(map[string]bool)
+	addrs := make(map[string]net.Addr)
+	beacons := make(map[string]Beacon)
+	// Kept when a peer is lost, so a restart is seen after a death too.
+	incarnations := make(map[string]int64)
 
 	for {
 		updated := false
 
 		conn.SetReadDeadline(time.Now().Add(interval))
-		n, _, _ := conn.ReadFrom(buf[0:])
+		n, from, err := conn.ReadFrom(buf[0:])
+		if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+			return
+		}
 
-		id := string(buf[:n])
+		beacon, ok := decodeBeacon(buf[:n])
+		id := beacon.ID
 
 		// Adding new connection
 		p.New = ""
-		if id != "" {
-			if _, idExists := lastSeen[id]; !idExists {
+		p.Events = nil
+		if ok {
+			p.Events = append(p.Events, det.Heartbeat(id)...)
+			if !members[id] {
+				members[id] = true
 				p.New = id
 				updated = true
 			}
 
-			lastSeen[id] = time.Now()
+			if from != nil && (addrs[id] == nil || addrs[id].String() != from.String()) {
+				addrs[id] = from
+				updated = true
+			}
+			if old, seen := beacons[id]; !seen || !old.Equal(beacon) {
+				beacons[id] = beacon
+				updated = true
+			}
+			if old, seen := incarnations[id]; seen && old != beacon.Incarnation {
+				p.Events = append(p.Events, failure.Event{Peer: id, Kind: failure.PeerRestarted})
+			}
+			incarnations[id] = beacon.Incarnation
 		}
 
 		// Removing dead connection
 		p.Lost = make([]string, 0)
-		for k, v := range lastSeen {
-			if time.Now().Sub(v) > timeout {
-				updated = true
-				p.Lost = append(p.Lost, k)
-				delete(lastSeen, k)
+		p.Events = append(p.Events, det.Check()...)
+		for _, e := range p.Events {
+			updated = true
+			suspected[e.Peer] = e.Kind == failure.PeerSuspected
+			if e.Kind == failure.PeerDead && members[e.Peer] {
+				p.Lost = append(p.Lost, e.Peer)
+				delete(members, e.Peer)
+				delete(addrs, e.Peer)
+				delete(beacons, e.Peer)
 			}
 		}
 
 		// Sending update
 		if updated {
-			p.Peers = make([]string, 0, len(lastSeen))
+			p.Peers = make([]string, 0, len(members))
+			p.Suspected = make([]string, 0)
+			p.Addrs = make(map[string]net.Addr, len(addrs))
+			for k, v := range addrs {
+				p.Addrs[k] = v
+			}
+			p.Beacons = make(map[string]Beacon, len(beacons))
+			for k, v := range beacons {
+				p.Beacons[k] = v
+			}
 
-			for k, _ := range lastSeen {
+			for k, _ := range members {
 				p.Peers = append(p.Peers, k)
+				if suspected[k] {
+					p.Suspected = append(p.Suspected, k)
+				}
 			}
 
 			sort.Strings(p.Peers)
+			sort.Strings(p.Suspected)
 			sort.Strings(p.Lost)
-			peerUpdateCh <- p
+			select {
+			case peerUpdateCh <- p:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,175 @@
+package unicast
+
+import (
+	"context"
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/message"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Unicast sends directed messages, such as an Ack to the master, to the
+// nodes they are for only, instead of broadcasting them. The address of each
+// node is kept in an AddressBook, seeded from the configuration and updated
+// from peer discovery.
+
+const bufSize = 8192
+
+var log = logging.For("unicast")
+
+// Packet is a message and the elevators it is for.
+type Packet struct {
+	To  []int
+	Msg message.Message
+}
+
+// AddressBook maps elevator IDs to the addresses their unicast conns listen
+// on.
+type AddressBook struct {
+	mu      sync.RWMutex
+	addrs   map[int]net.Addr
+	resolve Resolver
+}
+
+// Resolver gives the unicast address of elevator id from the address its
+// discovery beacons come from and the addresses they announce.
+type Resolver func(id int, from net.Addr, announced []string) net.Addr
+
+// NewAddressBook creates an empty address book that learns addresses with
+// resolve.
+func NewAddressBook(resolve Resolver) *AddressBook {
+	return &AddressBook{addrs: make(map[int]net.Addr), resolve: resolve}
+}
+
+// Set sets the address of elevator id.
+func (b *AddressBook) Set(id int, addr net.Addr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addrs[id] = addr
+}
+
+// Learn sets the address of elevator id from the address its beacons come
+// from and the addresses they announce.
+func (b *AddressBook) Learn(id int, from net.Addr, announced []string) {
+	addr := b.resolve(id, from, announced)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if old, ok := b.addrs[id]; !ok || old.String() != addr.String() {
+		log.Debug("learned address", "elevator", id, "addr", addr)
+	}
+	b.addrs[id] = addr
+}
+
+// Lookup returns the address of elevator id.
+func (b *AddressBook) Lookup(id int) (net.Addr, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	addr, ok := b.addrs[id]
+	return addr, ok
+}
+
+// IDs returns the elevators with a known address, sorted.
+func (b *AddressBook) IDs() []int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ids := make([]int, 0, len(b.addrs))
+	for id := range b.addrs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// UDPResolver returns a Resolver for UDP. It takes the first announced
+// address, with the host the beacons come from if its host is unspecified.
+// Without one it takes the host the beacons come from, and port(id).
+func UDPResolver(port func(id int) int) Resolver {
+	return func(id int, from net.Addr, announced []string) net.Addr {
+		addr := &net.UDPAddr{Port: port(id)}
+		if udp, ok := from.(*net.UDPAddr); ok {
+			addr.IP, addr.Zone = udp.IP, udp.Zone
+		}
+		for _, a := range announced {
+			host, p, err := net.SplitHostPort(a)
+			if err != nil {
+				continue
+			}
+			if addr.Port, err = strconv.Atoi(p); err != nil {
+				addr.Port = port(id)
+			}
+			if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+				addr.IP, addr.Zone = ip, ""
+			}
+			break
+		}
+		return addr
+	}
+}
+
+// Transmitter sends each packet on packets to the elevators it is for, until
+// ctx is done. A packet for an elevator without an address is dropped.
+func Transmitter(ctx context.Context, conn net.PacketConn, book *AddressBook, packets <-chan Packet) {
+	for {
+		var p Packet
+		select {
+		case p = <-packets:
+		case <-ctx.Done():
+			return
+		}
+		data, err := json.Marshal(p.Msg)
+		if err != nil {
+			log.Error("could not encode message", "type", p.Msg.Type(), "err", err)
+			continue
+		}
+		if len(data) > bufSize {
+			log.Error("message too long, dropped", "type", p.Msg.Type(), "length", len(data), "bufSize", bufSize)
+			continue
+		}
+		for _, id := range p.To {
+			addr, ok := book.Lookup(id)
+			if !ok {
+				log.Debug("no address, dropping message", "elevator", id, "type", p.Msg.Type(), "msgID", p.Msg.MsgID)
+				continue
+			}
+			if _, err := conn.WriteTo(data, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Warn("WriteTo failed", "elevator", id, "addr", addr, "err", err)
+			}
+		}
+	}
+}
+
+// Receiver decodes the messages arriving on conn and sends them on out, until
+// ctx is done or conn is closed.
+func Receiver(ctx context.Context, conn net.PacketConn, out chan<- message.Message) {
+	// Wake up a blocked ReadFrom when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	rejector := message.NewRejector()
+	var buf [bufSize]byte
+	for {
+		n, _, err := conn.ReadFrom(buf[:])
+		if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error("ReadFrom failed", "addr", conn.LocalAddr(), "err", err)
+			continue
+		}
+		var msg message.Message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			rejector.Reject(err)
+			continue
+		}
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
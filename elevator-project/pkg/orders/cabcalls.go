@@ -0,0 +1,47 @@
+package orders
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// SaveCabCalls writes the cab calls of an elevator to path, so they can be
+// served after a restart. The file is replaced atomically.
+func SaveCabCalls(path string, cab []bool) error {
+	data, err := json.Marshal(cab)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// TakeCabCalls reads and removes the cab calls saved to path. It returns nil
+// if there are none.
+func TakeCabCalls(path string) ([]bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cab []bool
+	if err := json.Unmarshal(data, &cab); err != nil {
+		return nil, err
+	}
+	return cab, os.Remove(path)
+}
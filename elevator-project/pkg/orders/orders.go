@@ -2,10 +2,12 @@ package orders
 
 import (
 	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/logging"
 	"errors"
-	"fmt"
 )
 
+var log = logging.For("orders")
+
 type RequestMatrix struct {
 	HallRequests [][2]bool
 	CabRequests  []bool
@@ -63,42 +65,23 @@ func (rm *RequestMatrix) HasCabRequest(floor int) (bool, error) {
 	return rm.CabRequests[floor], nil
 }
 
+// DebugPrint logs the request matrix at debug level.
 func (rm *RequestMatrix) DebugPrint() {
-	fmt.Println("==== Request Matrix ====")
-	for floor, hall := range rm.HallRequests {
-		cabStr := "off"
-		if rm.CabRequests[floor] {
-			cabStr = "on"
-		}
-		hallUpStr := "off"
-		if hall[0] {
-			hallUpStr = "on"
-		}
-		hallDownStr := "off"
-		if hall[1] {
-			hallDownStr = "on"
-		}
-		fmt.Printf("Floor %d: Cab: %s, Hall Up: %s, Hall Down: %s\n", floor, cabStr, hallUpStr, hallDownStr)
-	}
-	fmt.Println("========================")
+	log.Debug("request matrix", "hallRequests", rm.HallRequests, "cabRequests", rm.CabRequests)
 }
 
 func GetUnassignedOrders(rm *RequestMatrix) []drivers.ButtonEvent {
 	var orders []drivers.ButtonEvent
 
-	fmt.Println("[DEBUG] Checking request matrix...")
-
 	// Ensure RequestMatrix is properly initialized
 	if rm == nil {
-		fmt.Println("[ERROR] RequestMatrix is nil!")
+		log.Error("request matrix is nil")
 		return orders
 	}
 
 	for floor, hallReq := range rm.HallRequests {
-		fmt.Printf("[DEBUG] Floor %d HallRequests: %+v\n", floor, hallReq)
 		for dir, active := range hallReq {
 			if active {
-				fmt.Printf("[DEBUG] Found active request at Floor %d Direction %d\n", floor, dir)
 				orders = append(orders, drivers.ButtonEvent{
 					Floor:  floor,
 					Button: drivers.ButtonType(dir),
@@ -106,5 +89,6 @@ func GetUnassignedOrders(rm *RequestMatrix) []drivers.ButtonEvent {
 			}
 		}
 	}
+	log.Debug("unassigned orders", "orders", orders)
 	return orders
 }
@@ -0,0 +1,175 @@
+package record
+
+import (
+	"bufio"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/failure"
+	"elevator-project/pkg/logging"
+	"elevator-project/pkg/message"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recording holds every input that can change the decisions of one node: the
+// messages it handled, its driver inputs, its timer firings and the failure
+// detector events about its peers. Replaying the
+// inputs in order against a virtual clock reproduces the decisions.
+//
+// The file is JSON lines. The first line is a Start entry describing the node.
+
+type Kind string
+
+const (
+	Start       Kind = "start"
+	Message     Kind = "message"
+	Button      Kind = "button"
+	Floor       Kind = "floor"
+	Obstruction Kind = "obstruction"
+	StopButton  Kind = "stop"
+	Timer       Kind = "timer"
+	Liveness    Kind = "liveness"
+)
+
+// Timer names.
+const (
+	DoorTimer      = "door"
+	WorldviewTimer = "worldview"
+	HandoverTimer  = "handover"
+	SnapshotTimer  = "snapshot"
+)
+
+type Entry struct {
+	Time    time.Time            `json:"time"`
+	Kind    Kind                 `json:"kind"`
+	Start   *Header              `json:"start,omitempty"`
+	Message *message.Message     `json:"message,omitempty"`
+	Button  *drivers.ButtonEvent `json:"button,omitempty"`
+	Floor   int                  `json:"floor,omitempty"`
+	Value   bool                 `json:"value,omitempty"`
+	Timer   string               `json:"timer,omitempty"`
+	Failure *failure.Event       `json:"failure,omitempty"`
+}
+
+// Header describes the node at the start of the recording.
+type Header struct {
+	Node         int   `json:"node"`
+	NumFloors    int   `json:"numFloors"`
+	ServedFloors []int `json:"servedFloors,omitempty"`
+	StartFloor   int   `json:"startFloor"`
+	MasterID     int   `json:"masterID"`
+}
+
+var (
+	mu   sync.Mutex
+	file *bufio.Writer
+	f    *os.File
+	log  = logging.For("record")
+)
+
+// Open starts a recording in path. Until it is called the Add functions do
+// nothing.
+func Open(path string, header Header) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	f = out
+	file = bufio.NewWriter(out)
+	mu.Unlock()
+
+	add(Entry{Kind: Start, Start: &header})
+	return nil
+}
+
+// Close flushes and closes the recording.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	file.Flush()
+	err := f.Close()
+	f, file = nil, nil
+	return err
+}
+
+// Enabled reports whether a recording is in progress.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return f != nil
+}
+
+func AddMessage(msg message.Message)   { add(Entry{Kind: Message, Message: &msg}) }
+func AddButton(be drivers.ButtonEvent) { add(Entry{Kind: Button, Button: &be}) }
+func AddFloor(floor int)               { add(Entry{Kind: Floor, Floor: floor}) }
+func AddObstruction(value bool)        { add(Entry{Kind: Obstruction, Value: value}) }
+func AddStop(value bool)               { add(Entry{Kind: StopButton, Value: value}) }
+func AddTimer(name string)             { add(Entry{Kind: Timer, Timer: name}) }
+func AddLiveness(e failure.Event)      { add(Entry{Kind: Liveness, Failure: &e}) }
+
+func add(e Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return
+	}
+	e.Time = time.Now()
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Error("could not encode entry", "err", err, "kind", e.Kind)
+		return
+	}
+	file.Write(append(line, '\n'))
+	// Flush every entry so a crash keeps everything up to the crash.
+	if err := file.Flush(); err != nil {
+		log.Error("could not write entry", "err", err, "kind", e.Kind)
+	}
+}
+
+// ReadHeader loads the header of a recording, from its Start entry.
+func ReadHeader(path string) (Header, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer in.Close()
+
+	var e Entry
+	if err := json.NewDecoder(in).Decode(&e); err != nil || e.Kind != Start || e.Start == nil {
+		return Header{}, fmt.Errorf("%s: not a recording, missing start entry", path)
+	}
+	return *e.Start, nil
+}
+
+// Read loads a recording. The first entry is always the Start entry.
+func Read(path string) (Header, []Entry, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer in.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return Header{}, nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return Header{}, nil, err
+	}
+	if len(entries) == 0 || entries[0].Kind != Start || entries[0].Start == nil {
+		return Header{}, nil, fmt.Errorf("%s: not a recording, missing start entry", path)
+	}
+	return *entries[0].Start, entries, nil
+}
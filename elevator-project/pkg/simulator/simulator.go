@@ -0,0 +1,245 @@
+package simulator
+
+import (
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/drivers"
+	"sync"
+	"time"
+)
+
+// Elevator is a simulated elevator: a car moving between floors and a panel
+// of buttons and lamps. It implements drivers.Elevio, and reports the inputs
+// the drivers package polls for on its channels. The car moves on the ticks
+// of a clock and takes travelTime from one floor to the next, plus the time it
+// spends passing through the sensor zone of a floor.
+type Elevator struct {
+	mu              sync.Mutex
+	clk             clock.Clock
+	numFloors       int
+	travelTime      time.Duration
+	floor           int           // The floor the car is at, or last left
+	target          int           // The floor the car is heading for when between floors
+	between         bool          // Between floor and target
+	elapsed         time.Duration // In the sensor zone, or since leaving it
+	motor           drivers.MotorDirection
+	lamps           [][3]bool
+	floorIndicator  int
+	doorOpen        bool
+	stopLamp        bool
+	obstructed      bool
+	frozen          bool
+	stops           int // Times the door has opened
+	floorsTravelled int
+
+	Buttons     chan drivers.ButtonEvent
+	Floors      chan int
+	Obstruction chan bool
+	Stop        chan bool
+}
+
+// A moving car spends travelTime/sensorZone in the sensor zone of a floor,
+// where the floor sensor reports the floor and stopping the motor
+// stops the car at the floor.
+const sensorZone = 4
+
+// New creates a simulated elevator standing at startFloor.
+func New(numFloors int, startFloor int, travelTime time.Duration, clk clock.Clock) *Elevator {
+	return &Elevator{
+		clk:            clk,
+		numFloors:      numFloors,
+		travelTime:     travelTime,
+		floor:          startFloor,
+		lamps:          make([][3]bool, numFloors),
+		floorIndicator: startFloor,
+		Buttons:        make(chan drivers.ButtonEvent, 16),
+		Floors:         make(chan int, 16),
+		Obstruction:    make(chan bool, 16),
+		Stop:           make(chan bool, 16),
+	}
+}
+
+// Run moves the car every tick.
+func (s *Elevator) Run(tick time.Duration) {
+	ticker := s.clk.NewTicker(tick)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		s.move(tick)
+	}
+}
+
+func (s *Elevator) move(dt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen || s.motor == drivers.MD_Stop {
+		if !s.between {
+			s.elapsed = 0
+		}
+		return
+	}
+
+	dir := int(s.motor)
+	if !s.between {
+		next := s.floor + dir
+		if next < 0 || next >= s.numFloors {
+			return
+		}
+		if s.elapsed += dt; s.elapsed < s.travelTime/sensorZone {
+			return
+		}
+		s.target, s.between, s.elapsed = next, true, 0
+	} else if s.target-s.floor != dir {
+		// Turned around between two floors.
+		s.floor, s.target = s.target, s.floor
+		s.elapsed = s.travelTime - s.elapsed
+	}
+
+	s.elapsed += dt
+	if s.elapsed >= s.travelTime {
+		s.floor, s.between, s.elapsed = s.target, false, 0
+		s.floorsTravelled++
+		select {
+		case s.Floors <- s.floor:
+		default:
+		}
+	}
+}
+
+// Press presses a button on the panel.
+func (s *Elevator) Press(be drivers.ButtonEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen {
+		return
+	}
+	select {
+	case s.Buttons <- be:
+	default:
+	}
+}
+
+// PressStop presses the stop button.
+func (s *Elevator) PressStop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen {
+		return
+	}
+	select {
+	case s.Stop <- true:
+	default:
+	}
+}
+
+// SetObstructed flips the obstruction switch.
+func (s *Elevator) SetObstructed(obstructed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen || s.obstructed == obstructed {
+		return
+	}
+	s.obstructed = obstructed
+	select {
+	case s.Obstruction <- obstructed:
+	default:
+	}
+}
+
+// Freeze stops the car where it is and ignores all further output and input,
+// as when its node has crashed.
+func (s *Elevator) Freeze() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frozen = true
+	s.motor = drivers.MD_Stop
+}
+
+// Floor returns the floor the car is at, or -1 between floors.
+func (s *Elevator) Floor() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.between {
+		return -1
+	}
+	return s.floor
+}
+
+// LastFloor returns the floor the car is at, or last left.
+func (s *Elevator) LastFloor() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.floor
+}
+
+// Stops returns how many times the door has opened.
+func (s *Elevator) Stops() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stops
+}
+
+// FloorsTravelled returns how many floors the car has moved.
+func (s *Elevator) FloorsTravelled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.floorsTravelled
+}
+
+func (s *Elevator) DoorOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.doorOpen
+}
+
+func (s *Elevator) Lamp(button drivers.ButtonType, floor int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lamps[floor][button]
+}
+
+func (s *Elevator) SetMotorDirection(dir drivers.MotorDirection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.frozen {
+		s.motor = dir
+	}
+}
+
+func (s *Elevator) SetButtonLamp(button drivers.ButtonType, floor int, value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.frozen && floor >= 0 && floor < s.numFloors {
+		s.lamps[floor][button] = value
+	}
+}
+
+func (s *Elevator) SetFloorIndicator(floor int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.frozen {
+		s.floorIndicator = floor
+	}
+}
+
+func (s *Elevator) SetDoorOpenLamp(value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.frozen {
+		if value && !s.doorOpen {
+			s.stops++
+		}
+		s.doorOpen = value
+	}
+}
+
+func (s *Elevator) SetStopLamp(value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.frozen {
+		s.stopLamp = value
+	}
+}
+
+func (s *Elevator) GetFloor() int {
+	return s.Floor()
+}
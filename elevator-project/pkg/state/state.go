@@ -1,10 +1,13 @@
 package state
 
 import (
+	"elevator-project/pkg/clock"
 	"elevator-project/pkg/config"
 	"elevator-project/pkg/drivers"
 	"elevator-project/pkg/orders"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,28 +21,52 @@ type ElevatorStatus struct {
 	TravelDirection int
 	LastUpdated     time.Time
 	RequestMatrix   orders.RequestMatrix
+	ServedFloors    []bool // Empty means every floor is served
+	InService       bool   // False when an operator has taken the elevator out of service
+}
+
+// ServesFloor reports whether the elevator is allowed to stop at floor.
+func (es ElevatorStatus) ServesFloor(floor int) bool {
+	if len(es.ServedFloors) == 0 {
+		return true
+	}
+	return floor >= 0 && floor < len(es.ServedFloors) && es.ServedFloors[floor]
 }
 
 // Store holds a map of ElevatorStatus instances.
 type Store struct {
-	mu           sync.RWMutex
-	elevators    map[int]ElevatorStatus
-	HallRequests [][2]bool
+	mu            sync.RWMutex
+	elevators     map[int]ElevatorStatus
+	HallRequests  [][2]bool
+	confirmedHall [][2]bool // Hall requests the master has delegated
+	unreachable   map[int]bool
+	draining      map[int]bool
+	// serviceModes holds the service modes set by SetInService that the
+	// status broadcasts of the elevators have not caught up with yet.
+	serviceModes map[int]bool
+	clk          clock.Clock
 }
 
 // NewStore creates a new Store.
 func NewStore() *Store {
 
 	store := &Store{
-		elevators:    make(map[int]ElevatorStatus),
-		HallRequests: make([][2]bool, config.NumFloors),
+		elevators:     make(map[int]ElevatorStatus),
+		HallRequests:  make([][2]bool, config.NumFloors),
+		confirmedHall: make([][2]bool, config.NumFloors),
+		unreachable:   make(map[int]bool),
+		draining:      make(map[int]bool),
+		serviceModes:  make(map[int]bool),
+		clk:           clock.Real{},
 	}
 
-	for id := 1; id <= 3; id++ {
+	for id := 1; id <= config.NumElevators; id++ {
 		// Create the ElevatorStatus instance.
 		status := ElevatorStatus{
 			ElevatorID:    id,
 			RequestMatrix: *orders.NewRequestMatrix(config.NumFloors),
+			ServedFloors:  config.ServedFloorMask(id),
+			InService:     true,
 		}
 		store.elevators[id] = status
 	}
@@ -47,19 +74,33 @@ func NewStore() *Store {
 	return store
 }
 
-// UpdateStatus updates or adds an ElevatorStatus to the store.
+// UpdateStatus updates or adds an ElevatorStatus to the store. A status sent
+// before the elevator took a service mode set by SetInService keeps that mode.
 func (s *Store) UpdateStatus(status ElevatorStatus) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if inService, ok := s.serviceModes[status.ElevatorID]; ok {
+		if status.InService == inService {
+			delete(s.serviceModes, status.ElevatorID)
+		}
+		status.InService = inService
+	}
 	s.elevators[status.ElevatorID] = status
 }
 
+// SetClock sets the clock used to timestamp heartbeats.
+func (s *Store) SetClock(clk clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clk = clk
+}
+
 // UpdateHeartbeat updates the heartbeat timestamp for a given elevator.
 func (s *Store) UpdateHeartbeat(elevID int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	status := s.elevators[elevID]
-	status.LastUpdated = time.Now()
+	status.LastUpdated = s.clk.Now()
 	s.elevators[elevID] = status
 }
 
@@ -97,26 +138,229 @@ func (s *Store) ClearOrder(button drivers.ButtonEvent, elevatorID int) error {
 	if button.Floor < 0 || button.Floor >= len(s.HallRequests) {
 		return fmt.Errorf("floor index %d out of bounds", button.Floor)
 	}
+	if _, ok := s.elevators[elevatorID]; !ok {
+		return fmt.Errorf("unknown elevator %d", elevatorID)
+	}
+	status := s.elevators[elevatorID]
+	status.RequestMatrix = copyRequests(status.RequestMatrix)
 	switch button.Button {
 	case drivers.BT_Cab:
-		s.elevators[elevatorID].RequestMatrix.CabRequests[button.Floor] = false
+		status.RequestMatrix.CabRequests[button.Floor] = false
 
 	case drivers.BT_HallUp:
-		s.elevators[elevatorID].RequestMatrix.HallRequests[button.Floor][0] = false
+		status.RequestMatrix.HallRequests[button.Floor][0] = false
 		s.HallRequests[button.Floor][int(button.Button)] = false
+		s.confirmedHall[button.Floor][int(button.Button)] = false
 
 	case drivers.BT_HallDown:
-		s.elevators[elevatorID].RequestMatrix.HallRequests[button.Floor][0] = false
+		status.RequestMatrix.HallRequests[button.Floor][0] = false
 		s.HallRequests[button.Floor][int(button.Button)] = false
+		s.confirmedHall[button.Floor][int(button.Button)] = false
 
 	}
+	s.elevators[elevatorID] = status
 
 	return nil
 }
 
-// GetElevatorLights returns the Lights matrix for the given elevator ID.
+// copyRequests returns a copy of rm to change. The request matrices in the
+// store are not changed in place, since UpdateStatus and GetAll share them
+// with the callers.
+func copyRequests(rm orders.RequestMatrix) orders.RequestMatrix {
+	return orders.RequestMatrix{
+		HallRequests: slices.Clone(rm.HallRequests),
+		CabRequests:  slices.Clone(rm.CabRequests),
+	}
+}
+
+// GetHallOrders returns a copy of the hall requests.
 func (s *Store) GetHallOrders(elevatorID int) [][2]bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.HallRequests
+
+	hall := make([][2]bool, len(s.HallRequests))
+	copy(hall, s.HallRequests)
+	return hall
+}
+
+// SetInService records that an elevator has been taken in or out of service,
+// without waiting for its state broadcasts, which keep the old mode until the
+// elevator has taken the new one.
+func (s *Store) SetInService(elevID int, inService bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.elevators[elevID]
+	if !ok {
+		return
+	}
+	status.InService = inService
+	s.elevators[elevID] = status
+	s.serviceModes[elevID] = inService
+}
+
+// ForgetServiceMode drops a service mode set by SetInService that the
+// elevator has not taken, e.g. when it has restarted and lost it.
+func (s *Store) ForgetServiceMode(elevID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.serviceModes, elevID)
+}
+
+// SetReachable records whether the failure detector thinks an elevator is
+// alive. Unlike InService it is not part of the elevator's status, so its
+// state broadcasts do not overwrite it.
+func (s *Store) SetReachable(elevID int, reachable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reachable {
+		delete(s.unreachable, elevID)
+	} else {
+		s.unreachable[elevID] = true
+	}
+}
+
+// Reachable reports whether an elevator is thought to be alive. Elevators are
+// until the failure detector declares them dead.
+func (s *Store) Reachable(elevID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.unreachable[elevID]
+}
+
+// SetDraining records whether an elevator is draining, keeping its hall
+// orders but taking no new ones. Like reachability it is not part of the
+// elevator's status.
+func (s *Store) SetDraining(elevID int, draining bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if draining {
+		s.draining[elevID] = true
+	} else {
+		delete(s.draining, elevID)
+	}
+}
+
+// Draining reports whether an elevator is draining.
+func (s *Store) Draining(elevID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining[elevID]
+}
+
+// CancelHallRequest removes a hall request from the board and from every
+// elevator's request matrix.
+func (s *Store) CancelHallRequest(button drivers.ButtonEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if button.Floor < 0 || button.Floor >= len(s.HallRequests) {
+		return fmt.Errorf("floor index %d out of bounds", button.Floor)
+	}
+	if button.Button == drivers.BT_Cab {
+		return fmt.Errorf("not a hall request")
+	}
+	dir := int(button.Button)
+	s.HallRequests[button.Floor][dir] = false
+	s.confirmedHall[button.Floor][dir] = false
+	for id, status := range s.elevators {
+		if button.Floor < len(status.RequestMatrix.HallRequests) {
+			status.RequestMatrix = copyRequests(status.RequestMatrix)
+			status.RequestMatrix.HallRequests[button.Floor][dir] = false
+			s.elevators[id] = status
+		}
+	}
+	return nil
+}
+
+// ConfirmHallRequests marks every hall request in a delegation from the master
+// as confirmed. The delegation holds the full assignment, so the union of all
+// elevators' orders is the confirmed hall request board.
+func (s *Store) ConfirmHallRequests(orderData map[string][][2]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	confirmed := make([][2]bool, len(s.confirmedHall))
+	for _, hallOrders := range orderData {
+		for floor := 0; floor < len(hallOrders) && floor < len(confirmed); floor++ {
+			for dir := 0; dir < 2; dir++ {
+				if hallOrders[floor][dir] {
+					confirmed[floor][dir] = true
+					s.HallRequests[floor][dir] = true
+				}
+			}
+		}
+	}
+	s.confirmedHall = confirmed
+}
+
+// MergeHallRequests adds the hall requests in hall to the board, as when a
+// new master collects the boards of the other nodes.
+func (s *Store) MergeHallRequests(hall [][2]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for floor := 0; floor < len(hall) && floor < len(s.HallRequests); floor++ {
+		for dir := 0; dir < 2; dir++ {
+			s.HallRequests[floor][dir] = s.HallRequests[floor][dir] || hall[floor][dir]
+		}
+	}
+}
+
+// SetHallBoard replaces the hall request board and the confirmed hall
+// requests, as with a snapshot from the master.
+func (s *Store) SetHallBoard(hall, confirmed [][2]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for floor := 0; floor < len(s.HallRequests); floor++ {
+		var h, c [2]bool
+		if floor < len(hall) {
+			h = hall[floor]
+		}
+		if floor < len(confirmed) {
+			c = confirmed[floor]
+		}
+		s.HallRequests[floor], s.confirmedHall[floor] = h, c
+	}
+}
+
+// GetConfirmedHallRequests returns a copy of the confirmed hall requests.
+func (s *Store) GetConfirmedHallRequests() [][2]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	confirmed := make([][2]bool, len(s.confirmedHall))
+	copy(confirmed, s.confirmedHall)
+	return confirmed
+}
+
+// HasOrder reports whether the order is active, either on the hall request
+// board or, for cab orders, in the given elevator's request matrix.
+func (s *Store) HasOrder(button drivers.ButtonEvent, elevatorID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if button.Floor < 0 || button.Floor >= len(s.HallRequests) {
+		return false
+	}
+	if button.Button == drivers.BT_Cab {
+		cab := s.elevators[elevatorID].RequestMatrix.CabRequests
+		return button.Floor < len(cab) && cab[button.Floor]
+	}
+	return s.HallRequests[button.Floor][int(button.Button)]
+}
+
+// ServedBy returns the IDs of the elevators that serve the given floor.
+func (s *Store) ServedBy(floor int) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := []int{}
+	for id, status := range s.elevators {
+		if status.ServesFloor(floor) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
 }
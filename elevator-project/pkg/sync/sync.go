@@ -1,4 +1,52 @@
 package sync
 
 //Implements the synchronization mechanism (tracking sequence numbers,
-//detecting gaps, handling periodic full-state updates, and managing ACKs).
\ No newline at end of file
+//detecting gaps, handling periodic full-state updates, and managing ACKs).
+
+// seqWindow is how many sequence numbers back a sender's history is kept. A
+// sequence number further back than this is taken to mean the sender has
+// restarted its counter.
+const seqWindow = 1024
+
+// Tracker follows the message sequence numbers (MsgID) of every sender and
+// detects duplicates and gaps. It is not safe for concurrent use.
+type Tracker struct {
+	senders map[int]*senderSeq
+}
+
+type senderSeq struct {
+	highest int
+	seen    map[int]bool
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{senders: make(map[int]*senderSeq)}
+}
+
+// Observe records seq from sender. It reports whether the message has been
+// seen before, and how many sequence numbers were skipped since the highest
+// one seen so far. Late arrivals of skipped numbers are neither duplicates
+// nor gaps.
+func (t *Tracker) Observe(sender int, seq int) (duplicate bool, missed int) {
+	s, ok := t.senders[sender]
+	if !ok || seq < s.highest-seqWindow {
+		t.senders[sender] = &senderSeq{highest: seq, seen: map[int]bool{seq: true}}
+		return false, 0
+	}
+
+	if s.seen[seq] {
+		return true, 0
+	}
+	s.seen[seq] = true
+
+	if seq > s.highest {
+		missed = seq - s.highest - 1
+		s.highest = seq
+		for old := range s.seen {
+			if old < s.highest-seqWindow {
+				delete(s.seen, old)
+			}
+		}
+	}
+	return false, missed
+}
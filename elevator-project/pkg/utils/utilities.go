@@ -3,6 +3,9 @@ package utils
 import (
 	"elevator-project/pkg/config"
 	"elevator-project/pkg/drivers"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 func ButtonTypeToString(b drivers.ButtonType) string {
@@ -40,3 +43,23 @@ func ElevatorIntToString(num int) string {
 		return ""
 	}
 }
+
+// ParseFloorList parses a comma separated list of floors, e.g. "1,2,3".
+func ParseFloorList(s string) ([]int, error) {
+	floors := []int{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		floor, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid floor %q", field)
+		}
+		if floor < 0 || floor >= config.NumFloors {
+			return nil, fmt.Errorf("floor %d out of range", floor)
+		}
+		floors = append(floors, floor)
+	}
+	return floors, nil
+}
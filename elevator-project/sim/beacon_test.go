@@ -0,0 +1,41 @@
+package sim
+
+import (
+	"elevator-project/app"
+	"elevator-project/pkg/network/peers"
+	"testing"
+	"time"
+)
+
+// The beacons a node sees follow the role and service mode of their senders.
+func TestBeaconsFollowRoleAndService(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	c.Advance(time.Second)
+	beacons := c.Member(3).Node.Peers().Beacons
+	if b := beacons["1"]; b.Role != peers.RoleMaster || !b.InService {
+		t.Errorf("beacon of node 1 is %+v, want an in service master", b)
+	}
+	if b := beacons["2"]; b.Role != peers.RoleSlave || b.Incarnation == 0 {
+		t.Errorf("beacon of node 2 is %+v, want a slave with an incarnation", b)
+	}
+
+	if err := app.NewOperator(c.Member(1).Node).SetInService(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewOperator(c.Member(1).Node).HandOverMaster(3); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	beacons = c.Member(1).Node.Peers().Beacons
+	if b := beacons["2"]; b.InService {
+		t.Errorf("beacon of node 2 is %+v, want it out of service", b)
+	}
+	if b := beacons["3"]; b.Role != peers.RoleMaster || b.Term == 0 {
+		t.Errorf("beacon of node 3 is %+v, want a master in a new term", b)
+	}
+	if b := beacons["1"]; b.Role != peers.RoleSlave {
+		t.Errorf("beacon of node 1 is %+v, want a slave", b)
+	}
+}
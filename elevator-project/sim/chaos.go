@@ -0,0 +1,195 @@
+package sim
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/network/faults"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Chaos describes the random scenarios Generate makes. Every disturbance is
+// undone before the last Settle of the run, which is left calm for the
+// cluster to serve what is left.
+type Chaos struct {
+	Nodes    int
+	Duration time.Duration
+	Settle   time.Duration
+	// Mean time between events of each kind. Zero disables the kind.
+	Presses      time.Duration
+	Crashes      time.Duration
+	Obstructions time.Duration
+	Stops        time.Duration
+	Partitions   time.Duration
+}
+
+var DefaultChaos = Chaos{
+	Nodes:        3,
+	Duration:     3 * time.Minute,
+	Settle:       time.Minute,
+	Presses:      3 * time.Second,
+	Crashes:      30 * time.Second,
+	Obstructions: 20 * time.Second,
+	Stops:        30 * time.Second,
+	Partitions:   40 * time.Second,
+}
+
+// Generate makes the random scenario of seed. It expects no lost orders, no
+// lamps without calls and at most one master per partition.
+func (ch Chaos) Generate(seed int64) *Scenario {
+	g := &generator{
+		Chaos: ch,
+		rnd:   rand.New(rand.NewSource(seed)),
+		end:   ch.Duration - ch.Settle,
+		s:     &Scenario{Nodes: ch.Nodes, Floors: config.NumFloors, Duration: ch.Duration},
+	}
+	g.each(ch.Presses, g.press)
+	g.each(ch.Crashes, g.crash)
+	g.each(ch.Obstructions, g.obstruct)
+	g.each(ch.Stops, g.stop)
+	g.each(ch.Partitions, g.partition)
+	sort.SliceStable(g.s.Steps, func(i, j int) bool { return g.s.Steps[i].At < g.s.Steps[j].At })
+
+	g.s.Criteria = []Criterion{
+		{Kind: "hallServed", Within: ch.Settle},
+		{Kind: "cabNotLost"},
+		{Kind: "noPhantomLamps"},
+		{Kind: "oneMaster"},
+	}
+	return g.s
+}
+
+type generator struct {
+	Chaos
+	rnd  *rand.Rand
+	end  time.Duration // Of the disturbances
+	s    *Scenario
+	busy map[string]time.Duration // Until when a node or the network is disturbed
+}
+
+// each calls event at random times, mean apart, until the end of the
+// disturbances.
+func (g *generator) each(mean time.Duration, event func(at time.Duration)) {
+	if mean <= 0 {
+		return
+	}
+	for at := g.after(0, mean); at < g.end; at = g.after(at, mean) {
+		event(at)
+	}
+}
+
+// after returns a random time, on average mean after t, rounded to 100ms.
+func (g *generator) after(t time.Duration, mean time.Duration) time.Duration {
+	return (t + time.Duration(g.rnd.ExpFloat64()*float64(mean))).Round(100 * time.Millisecond)
+}
+
+// until returns a random time 1s to 10s after t, but before the end of the
+// disturbances.
+func (g *generator) until(t time.Duration) time.Duration {
+	u := t + time.Second + time.Duration(g.rnd.Intn(90))*100*time.Millisecond
+	if u > g.end {
+		u = g.end
+	}
+	return u
+}
+
+// claim marks what as disturbed from at until until, and reports whether it
+// was calm at at.
+func (g *generator) claim(what string, at, until time.Duration) bool {
+	if g.busy == nil {
+		g.busy = make(map[string]time.Duration)
+	}
+	if g.busy[what] >= at {
+		return false
+	}
+	g.busy[what] = until
+	return true
+}
+
+func (g *generator) node() int {
+	return 1 + g.rnd.Intn(g.Nodes)
+}
+
+func (g *generator) add(step Step) {
+	g.s.Steps = append(g.s.Steps, step)
+}
+
+func (g *generator) press(at time.Duration) {
+	be := drivers.ButtonEvent{Floor: g.rnd.Intn(config.NumFloors), Button: drivers.ButtonType(g.rnd.Intn(3))}
+	if be.Button == drivers.BT_HallUp && be.Floor == config.NumFloors-1 {
+		be.Button = drivers.BT_HallDown
+	} else if be.Button == drivers.BT_HallDown && be.Floor == 0 {
+		be.Button = drivers.BT_HallUp
+	}
+	g.add(Step{At: at, Action: "press", Node: g.node(), Button: be})
+}
+
+func (g *generator) crash(at time.Duration) {
+	node, until := g.node(), g.until(at)
+	if g.claim(fmt.Sprint("crash ", node), at, until) {
+		g.add(Step{At: at, Action: "kill", Node: node})
+		g.add(Step{At: until, Action: "restart", Node: node})
+	}
+}
+
+func (g *generator) obstruct(at time.Duration) {
+	node, until := g.node(), g.until(at)
+	if g.claim(fmt.Sprint("obstruction ", node), at, until) {
+		g.add(Step{At: at, Action: "obstruct", Node: node})
+		g.add(Step{At: until, Action: "release", Node: node})
+	}
+}
+
+func (g *generator) stop(at time.Duration) {
+	g.add(Step{At: at, Action: "stop", Node: g.node()})
+}
+
+// partition splits the nodes in two.
+func (g *generator) partition(at time.Duration) {
+	until := g.until(at)
+	if !g.claim("network", at, until) {
+		return
+	}
+	var moved []int
+	for node := 1; node <= g.Nodes; node++ {
+		if g.rnd.Intn(2) == 0 {
+			moved = append(moved, node)
+		}
+	}
+	if len(moved) == 0 || len(moved) == g.Nodes {
+		moved = []int{g.node()}
+	}
+	for _, node := range moved {
+		g.add(Step{At: at, Action: "faults", Node: node, Faults: faults.Config{Partition: "a"}})
+		g.add(Step{At: until, Action: "faults", Node: node})
+	}
+}
+
+// Shrink removes steps from s for as long as fails still holds, and returns
+// the smallest scenario found. The cluster is not entirely deterministic, so
+// fails should be true for the scenarios that fail most of the time.
+func Shrink(s *Scenario, fails func(*Scenario) bool) *Scenario {
+	best := s
+	for chunk := len(best.Steps) / 2; chunk >= 1; chunk /= 2 {
+		for i := 0; i < len(best.Steps); {
+			if candidate := best.without(i, chunk); fails(candidate) {
+				best = candidate
+			} else {
+				i += chunk
+			}
+		}
+	}
+	return best
+}
+
+// without returns a copy of s without n steps from i.
+func (s *Scenario) without(i, n int) *Scenario {
+	if i+n > len(s.Steps) {
+		n = len(s.Steps) - i
+	}
+	c := *s
+	c.Steps = append(append([]Step(nil), s.Steps[:i]...), s.Steps[i+n:]...)
+	return &c
+}
@@ -0,0 +1,103 @@
+package sim
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+var (
+	chaosRuns   = flag.Int("chaos.runs", 0, "Number of random scenarios TestChaos runs")
+	chaosSeed   = flag.Int64("chaos.seed", 0, "Seed of the first random scenario (default from the time)")
+	chaosShrink = flag.Bool("chaos.shrink", true, "Shrink failing random scenarios to minimal reproductions")
+)
+
+// TestChaos runs random scenarios, e.g.
+//
+//	go test ./sim -run Chaos -chaos.runs=20 -timeout=1h
+//
+// and prints a minimal reproduction of each failure, as a scenario script.
+func TestChaos(t *testing.T) {
+	if *chaosRuns == 0 {
+		t.Skip("run with -chaos.runs=N")
+	}
+	seed := *chaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	for run := 0; run < *chaosRuns; run++ {
+		s := DefaultChaos.Generate(seed)
+		r := Run(s)
+		if len(r.Violations) == 0 {
+			t.Logf("seed %d: ok, %d calls", seed, len(r.Calls))
+			seed++
+			continue
+		}
+		for _, v := range r.Violations {
+			t.Logf("seed %d: %s", seed, v)
+		}
+
+		kind := r.Violations[0].Kind
+		for _, criterion := range s.Criteria {
+			if criterion.Kind == kind {
+				s.Criteria = []Criterion{criterion}
+			}
+		}
+		if *chaosShrink {
+			s = Shrink(s, func(s *Scenario) bool { return Run(s).Violated(kind) })
+		}
+		t.Errorf("seed %d: %s\nminimal scenario failing %q:\n%s", seed, r.Violations[0], s.Criteria[0], s)
+		seed++
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s := DefaultChaos.Generate(1)
+	if len(s.Steps) == 0 {
+		t.Fatal("no steps generated")
+	}
+	if again := DefaultChaos.Generate(1); again.String() != s.String() {
+		t.Errorf("seed 1 generated two different scenarios:\n%s\n%s", s, again)
+	}
+	parsed, err := Parse(s.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != s.String() {
+		t.Errorf("scenario changed when parsed:\n%s\n%s", s, parsed)
+	}
+	for _, step := range s.Steps {
+		if step.At >= DefaultChaos.Duration-DefaultChaos.Settle && step.Action != "restart" && step.Action != "release" && step.Action != "faults" {
+			t.Errorf("%s in the settling time", step)
+		}
+	}
+}
+
+func TestShrink(t *testing.T) {
+	s, err := Parse(`
+		at 1s press up 0 on 1
+		at 2s kill 2
+		at 3s press cab 1 on 3
+		at 4s obstruct 1
+		at 5s stop 2
+		at 6s restart 2
+		at 7s press down 3 on 2
+		at 8s release 1
+		at 9s faults 3 partition=a
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fails := func(s *Scenario) bool {
+		var kill, press bool
+		for _, step := range s.Steps {
+			kill = kill || step.String() == "at 2s kill 2"
+			press = press || step.String() == "at 7s press down 3 on 2"
+		}
+		return kill && press
+	}
+	shrunk := Shrink(s, fails)
+	if len(shrunk.Steps) != 2 || !fails(shrunk) {
+		t.Errorf("shrunk to\n%s", shrunk)
+	}
+}
@@ -0,0 +1,254 @@
+package sim
+
+import (
+	"context"
+	"elevator-project/app"
+	"elevator-project/pkg/clock"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/elevator"
+	"elevator-project/pkg/failure"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/bcast"
+	"elevator-project/pkg/network/faults"
+	"elevator-project/pkg/network/memnet"
+	"elevator-project/pkg/network/peers"
+	"elevator-project/pkg/network/unicast"
+	"elevator-project/pkg/simulator"
+	"net"
+	"sync"
+	"time"
+)
+
+// TravelTime is the time a simulated car takes from one floor to the next.
+const TravelTime = 2 * time.Second
+
+// tick is how often the virtual clock is advanced, and the simulated cars
+// are moved.
+const tick = 10 * time.Millisecond
+
+// pause is the wall time given to the nodes' goroutines after each tick.
+const pause = 200 * time.Microsecond
+
+// Cluster runs nodes in one process, on simulated elevators and an in-memory
+// network, with time on a virtual clock.
+type Cluster struct {
+	Clock   *clock.Virtual
+	Network *memnet.Network
+	Members []*Member
+	start   time.Time
+}
+
+// Member is one node of a cluster with its hardware.
+type Member struct {
+	ID       int
+	Node     *app.Node
+	Hardware *simulator.Elevator
+	Faults   *faults.Injector
+	Killed   bool // Not running, after a crash or a shutdown
+	conns    []net.PacketConn
+	ctx      context.Context // Done when the node stops
+	cancel   context.CancelFunc
+	left     chan struct{}  // Closed when a shutdown has finished
+	cabCalls []bool         // Left by a shutdown, for Restart
+	network  sync.WaitGroup // The goroutines of the node's network
+}
+
+// spawn runs f in a goroutine of the node's network.
+func (m *Member) spawn(f func()) {
+	m.network.Add(1)
+	go func() {
+		defer m.network.Done()
+		f()
+	}()
+}
+
+// NewCluster starts nodes 1 to size, each with its car at floor 0. It sets
+// config.NumElevators to size.
+func NewCluster(size int) *Cluster {
+	config.NumElevators = size
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &Cluster{
+		Clock:   clock.NewVirtual(start),
+		Network: memnet.New(),
+		start:   start,
+	}
+	for id := 1; id <= size; id++ {
+		c.Members = append(c.Members, c.startMember(id, 0, faults.NewInjector(int64(id), c.Clock)))
+	}
+	return c
+}
+
+// startMember starts node id with its car at floor. The faults of inj apply to
+// the network of the node.
+func (c *Cluster) startMember(id int, floor int, inj *faults.Injector) *Member {
+	m := &Member{
+		ID:       id,
+		Node:     app.NewNode(id, c.Clock),
+		Hardware: simulator.New(config.NumFloors, floor, TravelTime, c.Clock),
+		Faults:   inj,
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	bcastConn := m.Faults.Wrap(c.Network.Listen(id, config.BCport))
+	peersConn := m.Faults.Wrap(c.Network.Listen(id, config.P2Pport))
+	m.conns = []net.PacketConn{bcastConn, peersConn}
+
+	netTx := make(chan message.Message)
+	netRx := make(chan message.Message)
+	peerUpdates := make(chan peers.PeerUpdate)
+	m.spawn(func() { bcast.TransmitterOn(m.ctx, bcastConn, memnet.Broadcast(config.BCport), netTx) })
+	m.spawn(func() { bcast.ReceiverOn(m.ctx, bcastConn, netRx) })
+	if config.Unicast {
+		unicastConn := m.Faults.Wrap(c.Network.Listen(id, config.UnicastPort(id)))
+		m.conns = append(m.conns, unicastConn)
+		uniTx := make(chan unicast.Packet)
+		book := unicast.NewAddressBook(func(id int, from net.Addr, announced []string) net.Addr {
+			addr, _ := from.(memnet.Addr)
+			return memnet.Addr{Node: addr.Node, Port: config.UnicastPort(id)}
+		})
+		m.spawn(func() { unicast.Transmitter(m.ctx, unicastConn, book, uniTx) })
+		m.spawn(func() { unicast.Receiver(m.ctx, unicastConn, netRx) })
+		m.Node.SetUnicast(uniTx, book, unicastConn.LocalAddr())
+	}
+	beacon := m.Node.Beacon()
+	m.spawn(func() {
+		peers.TransmitterOn(m.ctx, peersConn, memnet.Broadcast(config.P2Pport), beacon, m.Node.BeaconUpdates(), make(chan bool), c.Clock)
+	})
+	m.spawn(func() { peers.ReceiverOn(m.ctx, peersConn, peerUpdates, failure.New(failure.DefaultConfig(), c.Clock)) })
+	m.Node.Connect(m.ctx, netTx, netRx, peerUpdates)
+
+	e := elevator.NewElevatorWith(id, floor, m.Hardware, c.Clock, m.Node.MsgTx, m.Node.MsgCounter())
+	m.Node.SetElevator(e, m.Hardware)
+	go m.Hardware.Run(tick)
+	m.Node.Start(m.ctx, app.Inputs{
+		Buttons:     m.Hardware.Buttons,
+		Floors:      m.Hardware.Floors,
+		Obstruction: m.Hardware.Obstruction,
+		Stop:        m.Hardware.Stop,
+	})
+	return m
+}
+
+// Member returns node id, or nil.
+func (c *Cluster) Member(id int) *Member {
+	for _, m := range c.Members {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// Now returns the time since the cluster started.
+func (c *Cluster) Now() time.Duration {
+	return c.Clock.Since(c.start)
+}
+
+// Advance runs the cluster for d of virtual time.
+func (c *Cluster) Advance(d time.Duration) {
+	for end := c.Now() + d; c.Now() < end; {
+		c.Step()
+	}
+}
+
+// Step advances the virtual clock by one tick and gives the nodes time to
+// react. Nodes that have finished shutting down are stopped.
+func (c *Cluster) Step() {
+	c.Clock.Advance(tick)
+	time.Sleep(pause)
+	for _, m := range c.Members {
+		if m.left == nil || m.Killed {
+			continue
+		}
+		select {
+		case <-m.left:
+			c.Kill(m.ID)
+		default:
+		}
+	}
+}
+
+// Kill crashes node id: its goroutines stop, it is cut off from the network
+// and its car stops where it is.
+func (c *Cluster) Kill(id int) {
+	m := c.Member(id)
+	m.Killed = true
+	m.cancel()
+	c.Network.SetConnected(id, false)
+	m.Hardware.Freeze()
+}
+
+// Shutdown starts shutting node id down gracefully, as on SIGTERM. The node
+// is stopped by the Step after it has left, and its cab calls are kept for
+// Restart.
+func (c *Cluster) Shutdown(id int) {
+	m := c.Member(id)
+	c.leave(m, m.Node.Shutdown, config.ShutdownTimeout)
+}
+
+// Drain starts draining node id, as on SIGUSR1: it serves its hall orders
+// before it stops. Its cab calls are kept for Restart as after Shutdown.
+func (c *Cluster) Drain(id int) {
+	m := c.Member(id)
+	c.leave(m, m.Node.Drain, config.DrainTimeout)
+}
+
+// leave runs leave on m within timeout, unless m is stopped or leaving.
+func (c *Cluster) leave(m *Member, leave func(context.Context) []bool, timeout time.Duration) {
+	if m.Killed || m.left != nil {
+		return
+	}
+	m.left = make(chan struct{})
+	go func() {
+		ctx, cancel := clock.WithTimeout(m.ctx, c.Clock, timeout)
+		defer cancel()
+		m.cabCalls = leave(ctx)
+		close(m.left)
+	}()
+}
+
+// Restart starts node id anew, as after a crash or power cycle, with its car
+// at the floor the old one stopped at or last left. The node keeps its network
+// faults, and the cab calls left by a finished shutdown. A node that was not
+// killed is killed first.
+func (c *Cluster) Restart(id int) {
+	old := c.Member(id)
+	if !old.Killed {
+		c.Kill(id)
+	}
+	for _, conn := range old.conns {
+		conn.Close()
+	}
+	old.network.Wait()
+	old.Node.Wait()
+	m := c.startMember(id, old.Hardware.LastFloor(), old.Faults)
+	if old.left != nil {
+		select {
+		case <-old.left:
+			m.Node.RestoreCabCalls(old.cabCalls)
+		default:
+		}
+	}
+	for i := range c.Members {
+		if c.Members[i] == old {
+			c.Members[i] = m
+		}
+	}
+	c.Network.SetConnected(id, true)
+}
+
+// Stop stops every node and closes its network. It returns when the
+// goroutines of the nodes and their networks have, so the caller may change
+// the config they read.
+func (c *Cluster) Stop() {
+	for _, m := range c.Members {
+		m.cancel()
+		for _, conn := range m.conns {
+			conn.Close()
+		}
+	}
+	for _, m := range c.Members {
+		m.network.Wait()
+		m.Node.Wait()
+	}
+}
@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"elevator-project/pkg/drivers"
+	"testing"
+	"time"
+)
+
+// A draining elevator serves the hall orders it has and gets no new ones.
+func TestDrainServesOwnHallOrders(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+	c.Advance(time.Second)
+
+	first := drivers.ButtonEvent{Floor: 3, Button: drivers.BT_HallDown}
+	c.Member(1).Hardware.Press(first)
+	c.Advance(300 * time.Millisecond)
+	holder := holderOf(c, first)
+	if holder == nil {
+		t.Fatalf("no elevator has the hall call at %s", c.Now())
+	}
+	c.Drain(holder.ID)
+	c.Advance(500 * time.Millisecond)
+
+	second := drivers.ButtonEvent{Floor: 2, Button: drivers.BT_HallUp}
+	c.Member(1).Hardware.Press(second)
+	c.Advance(300 * time.Millisecond)
+	if other := holderOf(c, second); other == nil || other.ID == holder.ID {
+		t.Errorf("the hall call made during the drain is held by %v, want another elevator than %d", other, holder.ID)
+	}
+	if m := holderOf(c, first); m != nil && m.ID != holder.ID {
+		t.Errorf("the hall call of draining elevator %d moved to elevator %d", holder.ID, m.ID)
+	}
+
+	c.Advance(30 * time.Second)
+	select {
+	case <-holder.left:
+	default:
+		t.Fatalf("elevator %d has not drained at %s", holder.ID, c.Now())
+	}
+	for _, be := range []drivers.ButtonEvent{first, second} {
+		if m := holderOf(c, be); m != nil {
+			t.Errorf("elevator %d has not served %v at %s", m.ID, be, c.Now())
+		}
+	}
+}
+
+// A drained master hands its role on and keeps its cab calls for the restart,
+// after which it follows the new master.
+func TestDrainMasterKeepsCabCalls(t *testing.T) {
+	runScenario(t, `
+		run 60s
+		at 1s press cab 3 on 1
+		at 1s press up 2 on 2
+		at 2s drain 1
+		at 4s press down 3 on 3
+		at 30s restart 1
+		expect hall calls served within 20s
+		expect no cab call lost
+		expect one master per partition
+	`)
+}
+
+// holderOf returns the running member whose elevator has hall call be, or nil.
+func holderOf(c *Cluster, be drivers.ButtonEvent) *Member {
+	for _, m := range c.Members {
+		if !m.Killed && m.Node.Elevator().HallRequests()[be.Floor][be.Button] {
+			return m
+		}
+	}
+	return nil
+}
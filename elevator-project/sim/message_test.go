@@ -0,0 +1,136 @@
+package sim
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/memnet"
+	"elevator-project/pkg/orders"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Messages that do not decode into a valid payload are dropped by the
+// receiver instead of being handled with zero-valued fields.
+func TestInvalidMessagesAreDropped(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	// A node outside the cluster broadcasting hand-made messages, tagged
+	// the way bcast tags them.
+	conn := c.Network.Listen(99, config.BCport)
+	defer conn.Close()
+	send := func(msg string) {
+		packet, _ := json.Marshal(struct {
+			TypeId string
+			JSON   []byte
+		}{"message.Message", []byte(msg)})
+		conn.WriteTo(packet, memnet.Broadcast(config.BCport))
+	}
+
+	c.Advance(1200 * time.Millisecond)
+	for _, msg := range []string{
+		`{"protocol":2,"type":1,"schema":2,"elevatorID":99,"msgID":1,"body":{"event":{"Floor":3,"Button":1}}}`, // newer schema
+		`{"protocol":2,"type":1,"schema":1,"elevatorID":99,"msgID":2,"body":{"event":{"Floor":9,"Button":1}}}`, // no such floor
+		`{"protocol":2,"type":1,"schema":1,"elevatorID":99,"msgID":3,"body":null}`,                             // no payload
+		`{"protocol":2,"type":1,"schema":1,"elevatorID":0,"msgID":4,"body":{"event":{"Floor":1,"Button":0}}}`,  // no sender
+		`{"protocol":2,"type":5,"schema":1,"elevatorID":99,"msgID":5,"body":{}}`,                               // Heartbeat, no longer sent
+		`{"protocol":4,"type":1,"schema":1,"elevatorID":99,"msgID":6,"body":{"event":{"Floor":0,"Button":1}}}`, // newer protocol
+		`{"type":1,"schema":1,"elevatorID":99,"msgID":7,"body":{"event":{"Floor":9,"Button":0}}}`,              // envelope without protocol, no such floor
+		`{"protocol":2,"type":1,"schema":1,"elevatorID":99,"msgID":8,"body":{"event":{"Floor":2,"Button":1}}}`, // valid
+		`{"type":1,"schema":1,"elevatorID":99,"msgID":9,"body":{"event":{"Floor":3,"Button":0}}}`,              // valid envelope without protocol
+	} {
+		send(msg)
+	}
+	c.Advance(500 * time.Millisecond)
+
+	hall := c.Member(1).Node.ConfirmedHallRequests()
+	for floor, dirs := range hall {
+		for dir, confirmed := range dirs {
+			if want := floor == 2 && dir == 1 || floor == 3 && dir == 0; confirmed != want {
+				t.Errorf("hall request floor %d dir %d confirmed is %t, want %t", floor, dir, confirmed, want)
+			}
+		}
+	}
+}
+
+// baselineMessage is a Message as the first nodes sent it, before the
+// protocol version, the served floors and the service mode.
+type baselineMessage struct {
+	Type        int                  `json:"type"`
+	ElevatorID  int                  `json:"elevatorID"`
+	MsgID       int                  `json:"msgID"`
+	StateData   *baselineState       `json:"stateData,omitempty"`
+	ButtonEvent drivers.ButtonEvent  `json:"buttonEvent,omitempty"`
+	OrderData   map[string][][2]bool `json:"orderData,omitempty"`
+	AckID       int                  `json:"ackID,omitempty"`
+}
+
+type baselineState struct {
+	ElevatorID      int
+	State           int
+	Direction       int
+	CurrentFloor    int
+	TravelDirection int
+	LastUpdated     time.Time
+	RequestMatrix   orders.RequestMatrix
+}
+
+// Nodes can be upgraded one at a time, so the messages of the first nodes
+// decode, and a master announced to them is the one they read.
+func TestBaselineMessages(t *testing.T) {
+	decode := func(b baselineMessage) message.Message {
+		t.Helper()
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg message.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if msg.Protocol != 1 {
+			t.Errorf("%s: protocol %d, want 1", data, msg.Protocol)
+		}
+		return msg
+	}
+
+	rm := *orders.NewRequestMatrix(config.NumFloors)
+	rm.CabRequests[2] = true
+	msg := decode(baselineMessage{Type: 0, ElevatorID: 2, MsgID: 5, StateData: &baselineState{
+		ElevatorID:    2,
+		CurrentFloor:  1,
+		LastUpdated:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		RequestMatrix: rm,
+	}})
+	state, ok := msg.Payload.(message.State)
+	if !ok {
+		t.Fatalf("State decoded as %T", msg.Payload)
+	}
+	if s := state.Status; s.ElevatorID != 2 || s.CurrentFloor != 1 || !s.RequestMatrix.CabRequests[2] || !s.InService || s.ServedFloors != nil {
+		t.Errorf("State decoded as %+v", s)
+	}
+
+	msg = decode(baselineMessage{Type: 1, ElevatorID: 2, MsgID: 6, ButtonEvent: drivers.ButtonEvent{Floor: 3, Button: drivers.BT_HallDown}})
+	if p, ok := msg.Payload.(message.ButtonEvent); !ok || p.Event != (drivers.ButtonEvent{Floor: 3, Button: drivers.BT_HallDown}) {
+		t.Errorf("ButtonEvent decoded as %#v", msg.Payload)
+	}
+
+	msg = decode(baselineMessage{Type: 6, ElevatorID: 2, MsgID: 7})
+	if p, ok := msg.Payload.(message.MasterSlaveConfig); !ok || p.Master != 2 {
+		t.Errorf("MasterSlaveConfig decoded as %#v", msg.Payload)
+	}
+
+	data, err := json.Marshal(message.Message{ElevatorID: 1, MsgID: 8, Payload: message.MasterSlaveConfig{Master: 3}, Protocol: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent baselineMessage
+	if err := json.Unmarshal(data, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Type != 6 || sent.ElevatorID != 3 {
+		t.Errorf("MasterSlaveConfig for master 3 sent as %s, read by the first nodes as master %d", data, sent.ElevatorID)
+	}
+}
@@ -0,0 +1,33 @@
+package sim
+
+import (
+	"elevator-project/app"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every node counts in its own metrics: after a handover each reports the
+// new master, and only the old master has sent a MasterSlaveConfig.
+func TestMetricsPerNode(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	c.Advance(time.Second)
+	if err := app.NewOperator(c.Member(1).Node).HandOverMaster(2); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	for id := 1; id <= 3; id++ {
+		var b strings.Builder
+		c.Member(id).Node.Metrics().WriteAll(&b)
+		text := b.String()
+		if !strings.Contains(text, "\nelevator_master_id 2\n") {
+			t.Errorf("node %d does not report master 2:\n%s", id, text)
+		}
+		sent := strings.Contains(text, `elevator_messages_sent_total{type="MasterSlaveConfig"} 1`)
+		if sent != (id == 1) {
+			t.Errorf("node %d counts a MasterSlaveConfig sent: %t, want %t", id, sent, id == 1)
+		}
+	}
+}
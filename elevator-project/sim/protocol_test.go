@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"context"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/bcast"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A cluster with a node of an older protocol version speaks that version
+// until the node leaves, and serves calls meanwhile.
+func TestMixedProtocolVersions(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	c.Advance(time.Second)
+	for _, m := range c.Members {
+		if v := m.Node.Protocol(); v != message.ProtocolVersion {
+			t.Fatalf("node %d speaks protocol version %d, want %d", m.ID, v, message.ProtocolVersion)
+		}
+	}
+
+	// Node 3 comes back as an old node.
+	defer func(version int) { config.ProtocolVersion = version }(config.ProtocolVersion)
+	config.ProtocolVersion = 1
+	c.Restart(3)
+	config.ProtocolVersion = 0
+	c.Advance(500 * time.Millisecond)
+	for _, m := range c.Members {
+		if v := m.Node.Protocol(); v != 1 {
+			t.Fatalf("node %d speaks protocol version %d with an old node, want 1", m.ID, v)
+		}
+	}
+
+	// Every broadcast from now on is in version 1.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sniffer := c.Network.Listen(99, config.BCport)
+	defer sniffer.Close()
+	broadcasts := make(chan message.Message)
+	go bcast.ReceiverOn(ctx, sniffer, broadcasts)
+	var mu sync.Mutex
+	versions := make(map[int]int)
+	go func() {
+		for {
+			select {
+			case msg := <-broadcasts:
+				mu.Lock()
+				versions[msg.Protocol]++
+				mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	hall := drivers.ButtonEvent{Floor: 0, Button: drivers.BT_HallUp}
+	c.Member(3).Hardware.Press(drivers.ButtonEvent{Floor: 2, Button: drivers.BT_Cab})
+	c.Member(1).Hardware.Press(hall)
+	c.Advance(15 * time.Second)
+	for _, m := range c.Members {
+		if m.Node.Elevator().HallRequests()[hall.Floor][hall.Button] {
+			t.Errorf("elevator %d has not served the hall call at %s", m.ID, c.Now())
+		}
+	}
+	if c.Member(3).Node.Elevator().CabRequests()[2] {
+		t.Errorf("elevator 3 has not served the cab call at %s", c.Now())
+	}
+	mu.Lock()
+	if len(versions) != 1 || versions[1] == 0 {
+		t.Errorf("broadcasts by protocol version: %v, want version 1 only", versions)
+	}
+	mu.Unlock()
+
+	// Without the old node the others speak the newest version again.
+	c.Kill(3)
+	c.Advance(2 * time.Second)
+	for _, id := range []int{1, 2} {
+		if v := c.Member(id).Node.Protocol(); v != message.ProtocolVersion {
+			t.Errorf("node %d speaks protocol version %d after the old node left, want %d", id, v, message.ProtocolVersion)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package sim
+
+import (
+	"bufio"
+	"elevator-project/app"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/network/faults"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A scenario is a script of actions at given times, and the acceptance
+// criteria the cluster has to meet while running it. One statement per line,
+// # starts a comment:
+//
+//	nodes 3                         cluster size (default 3)
+//	floors 10                       floors in the building (default config.NumFloors), before any press
+//	run 60s                         how long to run (default 60s)
+//	at 1s press up 2 on 1           press hall up at floor 2 on node 1's panel
+//	at 1s press cab 3 on 2          press cab 3 in node 2's car
+//	at 3s kill 1                    crash node 1
+//	at 8s restart 1                 start node 1 anew
+//	at 3s shutdown 2                shut node 2 down gracefully, as on SIGTERM
+//	at 3s drain 2                   drain node 2 for a restart, as on SIGUSR1
+//	at 4s master 3                  hand the master role to node 3
+//	at 3s disconnect 2              cut node 2 off the network
+//	at 9s reconnect 2
+//	at 4s obstruct 2                flip node 2's obstruction switch on
+//	at 6s release 2                 and off
+//	at 7s stop 3                    press node 3's stop button
+//	at 5s faults 2 loss=30          set the network faults of node 2
+//	at 5s faults 3 partition=a      move node 3 to partition a
+//	expect hall calls served within 20s
+//	expect no cab call lost
+//	expect lamps match orders
+//	expect no lamp without a call
+//	expect hall lamps lit until served
+//	expect one master per partition
+//
+// A call counts as served when a car opens its door at the floor of the call,
+// for cab calls the car the call was made in. Lamps match orders when every
+// lamp of a live node follows that node's orders within lampGrace. A lamp
+// without a call is one lit for a button nobody has pressed: for hall buttons
+// on any node, for cab buttons in that car. A hall lamp must stay lit on
+// every live, connected node from lampGrace after the press until the call is
+// served. A partition is the nodes in the
+// same faults partition that are connected, and may have more than one master
+// for at most masterGrace.
+
+// lampGrace is how long a lamp may disagree with the orders of its node.
+const lampGrace = time.Second
+
+// masterGrace is how long a partition may have more than one master.
+const masterGrace = 3 * time.Second
+
+type Scenario struct {
+	Nodes    int
+	Floors   int
+	Duration time.Duration
+	Steps    []Step
+	Criteria []Criterion
+}
+
+// Step is an action at a point in time.
+type Step struct {
+	At     time.Duration
+	Action string // press, kill, restart, shutdown, drain, master, disconnect, reconnect, obstruct, release, stop or faults
+	Node   int
+	Button drivers.ButtonEvent
+	Faults faults.Config
+}
+
+type Criterion struct {
+	Kind   string // hallServed, cabNotLost, lampsMatch, noPhantomLamps, hallLampsLit or oneMaster
+	Within time.Duration
+}
+
+// Parse parses a scenario.
+func Parse(text string) (*Scenario, error) {
+	s := &Scenario{Nodes: 3, Floors: config.NumFloors, Duration: 60 * time.Second}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		statement, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(statement)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := s.parseStatement(fields); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	sort.SliceStable(s.Steps, func(i, j int) bool { return s.Steps[i].At < s.Steps[j].At })
+	return s, nil
+}
+
+func (s *Scenario) parseStatement(fields []string) error {
+	var err error
+	switch {
+	case fields[0] == "nodes" && len(fields) == 2:
+		s.Nodes, err = strconv.Atoi(fields[1])
+		return err
+	case fields[0] == "floors" && len(fields) == 2:
+		if len(s.Steps) > 0 {
+			return fmt.Errorf("floors after the first step")
+		}
+		if s.Floors, err = strconv.Atoi(fields[1]); err == nil && s.Floors < 2 {
+			err = fmt.Errorf("%d floors, need at least 2", s.Floors)
+		}
+		return err
+	case fields[0] == "run" && len(fields) == 2:
+		s.Duration, err = time.ParseDuration(fields[1])
+		return err
+	case fields[0] == "at" && len(fields) >= 3:
+		return s.parseStep(fields)
+	case strings.Join(fields, " ") == "expect no cab call lost":
+		s.Criteria = append(s.Criteria, Criterion{Kind: "cabNotLost"})
+		return nil
+	case strings.Join(fields, " ") == "expect lamps match orders":
+		s.Criteria = append(s.Criteria, Criterion{Kind: "lampsMatch"})
+		return nil
+	case strings.Join(fields, " ") == "expect no lamp without a call":
+		s.Criteria = append(s.Criteria, Criterion{Kind: "noPhantomLamps"})
+		return nil
+	case strings.Join(fields, " ") == "expect hall lamps lit until served":
+		s.Criteria = append(s.Criteria, Criterion{Kind: "hallLampsLit"})
+		return nil
+	case strings.Join(fields, " ") == "expect one master per partition":
+		s.Criteria = append(s.Criteria, Criterion{Kind: "oneMaster"})
+		return nil
+	case len(fields) == 6 && strings.Join(fields[:5], " ") == "expect hall calls served within":
+		within, err := time.ParseDuration(fields[5])
+		s.Criteria = append(s.Criteria, Criterion{Kind: "hallServed", Within: within})
+		return err
+	}
+	return fmt.Errorf("unknown statement %q", strings.Join(fields, " "))
+}
+
+func (s *Scenario) parseStep(fields []string) error {
+	at, err := time.ParseDuration(fields[1])
+	if err != nil {
+		return err
+	}
+	step := Step{At: at, Action: fields[2]}
+	switch {
+	case step.Action == "press" && len(fields) == 7 && fields[5] == "on":
+		switch fields[3] {
+		case "up":
+			step.Button.Button = drivers.BT_HallUp
+		case "down":
+			step.Button.Button = drivers.BT_HallDown
+		case "cab":
+			step.Button.Button = drivers.BT_Cab
+		default:
+			return fmt.Errorf("unknown button %q, use up, down or cab", fields[3])
+		}
+		if step.Button.Floor, err = strconv.Atoi(fields[4]); err != nil {
+			return err
+		}
+		if step.Button.Floor < 0 || step.Button.Floor >= s.Floors {
+			return fmt.Errorf("floor %d out of range", step.Button.Floor)
+		}
+		step.Node, err = s.parseNode(fields[6])
+	case step.Action == "faults" && len(fields) == 5:
+		if step.Node, err = s.parseNode(fields[3]); err != nil {
+			return err
+		}
+		step.Faults, err = faults.Parse(fields[4])
+	case len(fields) == 4 && (step.Action == "kill" || step.Action == "restart" || step.Action == "shutdown" || step.Action == "drain" || step.Action == "master" || step.Action == "disconnect" ||
+		step.Action == "reconnect" || step.Action == "obstruct" || step.Action == "release" || step.Action == "stop"):
+		step.Node, err = s.parseNode(fields[3])
+	default:
+		return fmt.Errorf("unknown action %q", strings.Join(fields[2:], " "))
+	}
+	if err != nil {
+		return err
+	}
+	s.Steps = append(s.Steps, step)
+	return nil
+}
+
+func (s *Scenario) parseNode(field string) (int, error) {
+	node, err := strconv.Atoi(field)
+	if err != nil || node < 1 || node > s.Nodes {
+		return 0, fmt.Errorf("invalid node %q", field)
+	}
+	return node, nil
+}
+
+var buttonNames = map[drivers.ButtonType]string{drivers.BT_HallUp: "up", drivers.BT_HallDown: "down", drivers.BT_Cab: "cab"}
+
+// String formats the scenario as a script Parse accepts.
+func (s *Scenario) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "nodes %d\nfloors %d\nrun %s\n", s.Nodes, s.Floors, s.Duration)
+	for _, step := range s.Steps {
+		fmt.Fprintln(&b, step)
+	}
+	for _, criterion := range s.Criteria {
+		fmt.Fprintln(&b, criterion)
+	}
+	return b.String()
+}
+
+func (step Step) String() string {
+	switch step.Action {
+	case "press":
+		return fmt.Sprintf("at %s press %s %d on %d", step.At, buttonNames[step.Button.Button], step.Button.Floor, step.Node)
+	case "faults":
+		return fmt.Sprintf("at %s faults %d %s", step.At, step.Node, step.Faults)
+	}
+	return fmt.Sprintf("at %s %s %d", step.At, step.Action, step.Node)
+}
+
+func (criterion Criterion) String() string {
+	switch criterion.Kind {
+	case "hallServed":
+		return fmt.Sprintf("expect hall calls served within %s", criterion.Within)
+	case "cabNotLost":
+		return "expect no cab call lost"
+	case "lampsMatch":
+		return "expect lamps match orders"
+	case "noPhantomLamps":
+		return "expect no lamp without a call"
+	case "hallLampsLit":
+		return "expect hall lamps lit until served"
+	case "oneMaster":
+		return "expect one master per partition"
+	}
+	return "expect " + criterion.Kind
+}
+
+// Call is a button press and when it was served.
+type Call struct {
+	Node     int
+	Button   drivers.ButtonEvent
+	Pressed  time.Duration
+	Served   time.Duration
+	IsServed bool
+}
+
+// Violation is a criterion not met.
+type Violation struct {
+	Kind string // The Kind of the criterion
+	At   time.Duration
+	Text string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.At, v.Text)
+}
+
+// Report is the outcome of running a scenario.
+type Report struct {
+	Calls      []*Call
+	Violations []Violation
+}
+
+// Run runs the scenario on a new cluster and checks its criteria.
+func Run(s *Scenario) *Report {
+	defer func(floors int) { config.NumFloors = floors }(config.NumFloors)
+	config.NumFloors = s.Floors
+	c := NewCluster(s.Nodes)
+	defer c.Stop()
+
+	r := &Report{}
+	lamps := newLampChecker()
+	phantoms := newPhantomChecker()
+	hallLamps := newHallLampChecker()
+	masters := newMasterChecker()
+	next := 0
+	for c.Now() < s.Duration {
+		for ; next < len(s.Steps) && s.Steps[next].At <= c.Now(); next++ {
+			r.apply(c, s.Steps[next])
+		}
+		c.Step()
+		r.observeDoors(c)
+		if s.has("lampsMatch") {
+			lamps.check(c, r)
+		}
+		if s.has("noPhantomLamps") {
+			phantoms.check(c, r)
+		}
+		if s.has("hallLampsLit") {
+			hallLamps.check(c, r)
+		}
+		if s.has("oneMaster") {
+			masters.check(c, r)
+		}
+	}
+
+	for _, criterion := range s.Criteria {
+		switch criterion.Kind {
+		case "hallServed":
+			for _, call := range r.Calls {
+				if call.Button.Button == drivers.BT_Cab {
+					continue
+				}
+				if call.IsServed && call.Served-call.Pressed > criterion.Within {
+					r.violate(c, "hallServed", "%s served after %s", call, call.Served-call.Pressed)
+				} else if !call.IsServed && s.Duration-call.Pressed >= criterion.Within {
+					r.violate(c, "hallServed", "%s not served", call)
+				}
+			}
+		case "cabNotLost":
+			for _, call := range r.Calls {
+				if call.Button.Button == drivers.BT_Cab && !call.IsServed && !c.Member(call.Node).Killed {
+					r.violate(c, "cabNotLost", "%s lost", call)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// Violated reports whether the criterion of kind was not met.
+func (r *Report) Violated(kind string) bool {
+	for _, v := range r.Violations {
+		if v.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scenario) has(kind string) bool {
+	for _, criterion := range s.Criteria {
+		if criterion.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) apply(c *Cluster, step Step) {
+	m := c.Member(step.Node)
+	switch step.Action {
+	case "press":
+		if !m.Killed {
+			r.Calls = append(r.Calls, &Call{Node: step.Node, Button: step.Button, Pressed: c.Now()})
+			m.Hardware.Press(step.Button)
+		}
+	case "kill":
+		c.Kill(step.Node)
+	case "restart":
+		c.Restart(step.Node)
+	case "shutdown":
+		c.Shutdown(step.Node)
+	case "drain":
+		c.Drain(step.Node)
+	case "master":
+		if !m.Killed {
+			app.NewOperator(m.Node).HandOverMaster(step.Node)
+		}
+	case "disconnect":
+		c.Network.SetConnected(step.Node, false)
+	case "reconnect":
+		if !m.Killed {
+			c.Network.SetConnected(step.Node, true)
+		}
+	case "obstruct":
+		m.Hardware.SetObstructed(true)
+	case "release":
+		m.Hardware.SetObstructed(false)
+	case "stop":
+		m.Hardware.PressStop()
+	case "faults":
+		m.Faults.Set(step.Faults)
+	}
+}
+
+// observeDoors marks the calls at floors where a car has its door open as
+// served.
+func (r *Report) observeDoors(c *Cluster) {
+	for _, m := range c.Members {
+		if m.Killed || !m.Hardware.DoorOpen() {
+			continue
+		}
+		floor := m.Hardware.Floor()
+		for _, call := range r.Calls {
+			if call.IsServed || call.Button.Floor != floor || call.Pressed >= c.Now() {
+				continue
+			}
+			if call.Button.Button == drivers.BT_Cab && call.Node != m.ID {
+				continue
+			}
+			call.IsServed, call.Served = true, c.Now()
+		}
+	}
+}
+
+// phantomChecker reports lamps lit for buttons nobody has pressed, once each
+// time they light up.
+type phantomChecker struct {
+	reported map[[3]int]bool // By node, floor and button
+}
+
+func newPhantomChecker() *phantomChecker {
+	return &phantomChecker{reported: make(map[[3]int]bool)}
+}
+
+func (p *phantomChecker) check(c *Cluster, r *Report) {
+	for _, m := range c.Members {
+		if m.Killed {
+			continue
+		}
+		for floor := 0; floor < config.NumFloors; floor++ {
+			for btn := drivers.BT_HallUp; btn <= drivers.BT_Cab; btn++ {
+				key := [3]int{m.ID, floor, int(btn)}
+				if !m.Hardware.Lamp(btn, floor) {
+					delete(p.reported, key)
+				} else if !p.reported[key] && !r.pressed(m.ID, drivers.ButtonEvent{Floor: floor, Button: btn}) {
+					r.violate(c, "noPhantomLamps", "node %d lamp %s %d lit without a call", m.ID, buttonNames[btn], floor)
+					p.reported[key] = true
+				}
+			}
+		}
+	}
+}
+
+// pressed reports whether be has been pressed, on node for cab buttons.
+func (r *Report) pressed(node int, be drivers.ButtonEvent) bool {
+	for _, call := range r.Calls {
+		if call.Button == be && (be.Button != drivers.BT_Cab || call.Node == node) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) violate(c *Cluster, kind string, format string, args ...interface{}) {
+	r.Violations = append(r.Violations, Violation{Kind: kind, At: c.Now(), Text: fmt.Sprintf(format, args...)})
+}
+
+func (call *Call) String() string {
+	return fmt.Sprintf("%s %d pressed on node %d at %s", buttonNames[call.Button.Button], call.Button.Floor, call.Node, call.Pressed)
+}
+
+// hallLampChecker tracks how long the lamp of each pending hall call has been
+// off on each node.
+type hallLampChecker struct {
+	offSince map[*Call]map[int]time.Duration // By call and node
+}
+
+func newHallLampChecker() *hallLampChecker {
+	return &hallLampChecker{offSince: make(map[*Call]map[int]time.Duration)}
+}
+
+func (h *hallLampChecker) check(c *Cluster, r *Report) {
+	for _, call := range r.Calls {
+		if call.Button.Button == drivers.BT_Cab || call.IsServed || c.Now()-call.Pressed < lampGrace {
+			delete(h.offSince, call)
+			continue
+		}
+		if h.offSince[call] == nil {
+			h.offSince[call] = make(map[int]time.Duration)
+		}
+		for _, m := range c.Members {
+			since, off := h.offSince[call][m.ID]
+			switch {
+			case m.Killed || !c.Network.Connected(m.ID) || m.Hardware.Lamp(call.Button.Button, call.Button.Floor):
+				delete(h.offSince[call], m.ID)
+			case !off:
+				h.offSince[call][m.ID] = c.Now()
+			case c.Now()-since > lampGrace:
+				r.violate(c, "hallLampsLit", "node %d lamp off for %s", m.ID, call)
+				h.offSince[call][m.ID] = c.Now()
+			}
+		}
+	}
+}
+
+// lampChecker tracks how long each lamp has disagreed with the orders of its
+// node.
+type lampChecker struct {
+	wrongSince map[[3]int]time.Duration // By node, floor and button
+}
+
+func newLampChecker() *lampChecker {
+	return &lampChecker{wrongSince: make(map[[3]int]time.Duration)}
+}
+
+func (l *lampChecker) check(c *Cluster, r *Report) {
+	for _, m := range c.Members {
+		if m.Killed {
+			continue
+		}
+		hall := m.Node.ConfirmedHallRequests()
+		cab := m.Node.Elevator().CabRequests()
+		for floor := 0; floor < config.NumFloors; floor++ {
+			for btn := drivers.BT_HallUp; btn <= drivers.BT_Cab; btn++ {
+				if (btn == drivers.BT_HallUp && floor == config.NumFloors-1) || (btn == drivers.BT_HallDown && floor == 0) {
+					continue
+				}
+				want := cab[floor]
+				if btn != drivers.BT_Cab {
+					want = hall[floor][btn]
+				}
+				key := [3]int{m.ID, floor, int(btn)}
+				since, wrong := l.wrongSince[key]
+				switch {
+				case m.Hardware.Lamp(btn, floor) == want:
+					delete(l.wrongSince, key)
+				case !wrong:
+					l.wrongSince[key] = c.Now()
+				case c.Now()-since > lampGrace:
+					r.violate(c, "lampsMatch", "node %d lamp %s %d is %t, orders say %t", m.ID, buttonNames[btn], floor, !want, want)
+					l.wrongSince[key] = c.Now()
+				}
+			}
+		}
+	}
+}
+
+// masterChecker tracks since when each partition has had more than one
+// master.
+type masterChecker struct {
+	splitSince map[string]time.Duration // By partition
+}
+
+func newMasterChecker() *masterChecker {
+	return &masterChecker{splitSince: make(map[string]time.Duration)}
+}
+
+func (mc *masterChecker) check(c *Cluster, r *Report) {
+	masters := make(map[string][]int)
+	for _, m := range c.Members {
+		if master, _ := m.Node.Master(); m.Killed || master != m.ID {
+			continue
+		}
+		partition := "partition " + m.Faults.Config().Partition
+		if !c.Network.Connected(m.ID) {
+			partition = fmt.Sprintf("node %d alone", m.ID)
+		}
+		masters[partition] = append(masters[partition], m.ID)
+	}
+	for partition, since := range mc.splitSince {
+		if len(masters[partition]) <= 1 {
+			delete(mc.splitSince, partition)
+		} else if c.Now()-since > masterGrace {
+			r.violate(c, "oneMaster", "%s has masters %v", partition, masters[partition])
+			mc.splitSince[partition] = c.Now()
+		}
+	}
+	for partition, ids := range masters {
+		if _, split := mc.splitSince[partition]; !split && len(ids) > 1 {
+			mc.splitSince[partition] = c.Now()
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package sim
+
+import (
+	"elevator-project/pkg/logging"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevels("error")
+	os.Exit(m.Run())
+}
+
+// runScenario runs a scenario and fails t for each violation. The nodes of
+// the cluster share one process, so the suite is run with the race detector:
+//
+//	go test -race ./sim/
+func runScenario(t *testing.T, text string) *Report {
+	t.Helper()
+	s, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Run(s)
+	for _, v := range r.Violations {
+		t.Error(v)
+	}
+	return r
+}
+
+func TestSingleHallCall(t *testing.T) {
+	runScenario(t, `
+		run 20s
+		at 1s press up 2 on 1
+		expect hall calls served within 15s
+		expect lamps match orders
+	`)
+}
+
+func TestHallAndCabCalls(t *testing.T) {
+	runScenario(t, `
+		run 40s
+		at 1s press down 3 on 2
+		at 1s press cab 2 on 1
+		at 2s press up 1 on 3
+		at 3s press cab 3 on 3
+		at 5s press up 0 on 1
+		expect hall calls served within 30s
+		expect no cab call lost
+		expect lamps match orders
+	`)
+}
+
+func TestObstruction(t *testing.T) {
+	runScenario(t, `
+		run 40s
+		at 1s press cab 1 on 2
+		at 3s obstruct 2
+		at 12s release 2
+		at 13s press cab 3 on 2
+		expect no cab call lost
+		expect lamps match orders
+	`)
+}
+
+func TestKilledSlaveKeepsOthersRunning(t *testing.T) {
+	runScenario(t, `
+		run 30s
+		at 1s kill 3
+		at 2s press cab 2 on 1
+		at 2s press cab 3 on 2
+		expect no cab call lost
+		expect lamps match orders
+	`)
+}
+
+func TestHallCallsAvoidKilledSlave(t *testing.T) {
+	// Elevator 3 waits at floor 3 when it dies, the best place for the call.
+	runScenario(t, `
+		run 60s
+		at 1s press cab 3 on 3
+		at 8s kill 3
+		at 9s press down 3 on 1
+		expect hall calls served within 30s
+	`)
+}
+
+func TestHallCallsOfKilledSlaveReassigned(t *testing.T) {
+	// The call is pressed on elevator 3 a second before it dies.
+	runScenario(t, `
+		run 60s
+		at 1s press down 3 on 3
+		at 2s kill 3
+		at 3s press up 1 on 2
+		expect hall calls served within 40s
+	`)
+}
+
+func TestKilledMasterReplaced(t *testing.T) {
+	// The call is pressed after the master dies, before the others notice.
+	runScenario(t, `
+		run 60s
+		at 1s kill 1
+		at 1100ms press down 3 on 3
+		expect hall calls served within 40s
+		expect one master per partition
+	`)
+}
+
+func TestShutdownHandsOffHallCalls(t *testing.T) {
+	runScenario(t, `
+		run 40s
+		at 1s press down 3 on 2
+		at 1500ms shutdown 1
+		expect hall calls served within 30s
+		expect lamps match orders
+	`)
+}
+
+func TestShutdownKeepsCabCalls(t *testing.T) {
+	runScenario(t, `
+		run 60s
+		at 1s press cab 3 on 3
+		at 2s shutdown 3
+		at 20s restart 3
+		expect no cab call lost
+	`)
+}
+
+// A slave that restarts before the others find it dead gets its cab calls
+// back from the master.
+func TestQuickRestartKeepsCabCalls(t *testing.T) {
+	runScenario(t, `
+		run 60s
+		at 1s press cab 3 on 2
+		at 2s restart 2
+		expect no cab call lost
+	`)
+}
+
+func TestMasterHandoverKeepsHallCalls(t *testing.T) {
+	runScenario(t, `
+		run 30s
+		at 1s disconnect 2
+		at 2s press down 3 on 1
+		at 4s reconnect 2
+		at 4s master 2
+		at 5s press down 2 on 3
+		expect hall calls served within 20s
+		expect hall lamps lit until served
+		expect one master per partition
+	`)
+}
+
+func TestSnapshotRepairsMissedDelegation(t *testing.T) {
+	runScenario(t, `
+		run 20s
+		at 1500ms faults 1 loss=100
+		at 2s press down 3 on 2
+		at 2500ms faults 1 off
+		expect hall calls served within 15s
+		expect hall lamps lit until served
+	`)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"at 1s press sideways 2 on 1",
+		"at 1s press up 9 on 1",
+		"at 1s kill 7",
+		"at soon kill 1",
+		"expect miracles",
+	} {
+		if _, err := Parse(text); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+			t.Errorf("Parse(%q) = %v, want a line 1 error", text, err)
+		}
+	}
+}
+
+// The snapshot, worldview and delegation boards grow with the floors and
+// elevators, and must still fit in a datagram.
+func TestTallBuilding(t *testing.T) {
+	runScenario(t, `
+		nodes 5
+		floors 10
+		run 60s
+		at 1s press up 0 on 1
+		at 1s press down 9 on 2
+		at 2s press up 5 on 3
+		at 2s press cab 8 on 4
+		at 3s press down 7 on 5
+		at 4s press cab 2 on 1
+		expect hall calls served within 40s
+		expect no cab call lost
+		expect lamps match orders
+		expect one master per partition
+	`)
+}
@@ -0,0 +1,39 @@
+package sim
+
+import (
+	"elevator-project/app"
+	"elevator-project/pkg/drivers"
+	"testing"
+	"time"
+)
+
+// The hall orders of an elevator taken out of service move to the others,
+// instead of being served by both.
+func TestOutOfServiceHandsOffHallOrders(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+	c.Advance(time.Second)
+
+	hall := drivers.ButtonEvent{Floor: 3, Button: drivers.BT_HallDown}
+	c.Member(1).Hardware.Press(hall)
+	c.Advance(300 * time.Millisecond)
+	holder := holderOf(c, hall)
+	if holder == nil {
+		t.Fatalf("no elevator has the hall call at %s", c.Now())
+	}
+	if err := app.NewOperator(c.Member(1).Node).SetInService(holder.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(500 * time.Millisecond)
+
+	if holder.Node.Elevator().HallRequests()[hall.Floor][hall.Button] {
+		t.Errorf("elevator %d out of service still has the hall call", holder.ID)
+	}
+	if other := holderOf(c, hall); other == nil {
+		t.Errorf("no elevator has the hall call after elevator %d left service", holder.ID)
+	}
+	c.Advance(20 * time.Second)
+	if m := holderOf(c, hall); m != nil {
+		t.Errorf("elevator %d has not served the hall call at %s", m.ID, c.Now())
+	}
+}
@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"elevator-project/app"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/memnet"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// A snapshot delayed past two master changes is not applied, though its
+// sender is master again.
+func TestStaleSnapshotIgnored(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	c.Advance(time.Second)
+	if err := app.NewOperator(c.Member(1).Node).HandOverMaster(2); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	if err := app.NewOperator(c.Member(2).Node).HandOverMaster(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	if master, term := c.Member(3).Node.Master(); master != 1 || term != 2 {
+		t.Fatalf("node 3 has master %d in term %d, want 1 in term 2", master, term)
+	}
+
+	lit := make([][2]bool, config.NumFloors)
+	lit[2][0] = true
+	data, err := json.Marshal(message.Message{
+		ElevatorID: 1,
+		MsgID:      1 << 30,
+		Payload:    message.Snapshot{Term: 1, HallRequests: lit, Assignment: map[string][][2]bool{"3": lit}, HallLamps: lit},
+		Protocol:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, _ := json.Marshal(struct {
+		TypeId string
+		JSON   []byte
+	}{"message.Message", data})
+	conn := c.Network.Listen(99, config.BCport)
+	defer conn.Close()
+	conn.WriteTo(packet, memnet.Broadcast(config.BCport))
+	c.Advance(100 * time.Millisecond)
+
+	if hall := c.Member(3).Node.Elevator().HallRequests(); hall[2][0] {
+		t.Error("hall order of the stale snapshot taken")
+	}
+}
+
+// A node that restarts takes the term of the master that announces itself
+// to it, though the master has not changed since it started out with node 1,
+// and announces that term in its beacons.
+func TestRestartedNodeTakesTerm(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	c.Advance(time.Second)
+	if err := app.NewOperator(c.Member(1).Node).HandOverMaster(2); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	if err := app.NewOperator(c.Member(2).Node).HandOverMaster(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	c.Restart(3)
+	c.Advance(3 * time.Second)
+	for id := 1; id <= 3; id++ {
+		if master, term := c.Member(id).Node.Master(); master != 1 || term != 2 {
+			t.Errorf("node %d has master %d in term %d, want 1 in term 2", id, master, term)
+		}
+	}
+	beacons := c.Member(2).Node.Peers().Beacons
+	if b1, b3 := beacons["1"], beacons["3"]; b1.Term != 2 || b3.Term != 2 {
+		t.Errorf("beacons of nodes 1 and 3 announce terms %d and %d, want 2", b1.Term, b3.Term)
+	}
+}
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Profile picks where a passenger comes from and goes to.
+type Profile func(rnd *rand.Rand) (from, to int)
+
+// Profiles are the traffic patterns of an office building, with floor 0 as
+// the lobby.
+var Profiles = map[string]Profile{
+	"uniform": uniform,
+	// Morning: almost everybody goes up from the lobby.
+	"uppeak": func(rnd *rand.Rand) (int, int) {
+		if rnd.Float64() < 0.85 {
+			return 0, 1 + rnd.Intn(config.NumFloors-1)
+		}
+		return uniform(rnd)
+	},
+	// Lunch: people go down to the lobby and back up.
+	"lunch": func(rnd *rand.Rand) (int, int) {
+		switch r := rnd.Float64(); {
+		case r < 0.4:
+			return 0, 1 + rnd.Intn(config.NumFloors-1)
+		case r < 0.8:
+			return 1 + rnd.Intn(config.NumFloors-1), 0
+		}
+		return uniform(rnd)
+	},
+	// Evening: almost everybody goes down to the lobby.
+	"downpeak": func(rnd *rand.Rand) (int, int) {
+		if rnd.Float64() < 0.85 {
+			return 1 + rnd.Intn(config.NumFloors-1), 0
+		}
+		return uniform(rnd)
+	},
+}
+
+// uniform picks two different floors at random.
+func uniform(rnd *rand.Rand) (int, int) {
+	from := rnd.Intn(config.NumFloors)
+	to := rnd.Intn(config.NumFloors - 1)
+	if to >= from {
+		to++
+	}
+	return from, to
+}
+
+// Traffic is passengers arriving at random, Rate per minute on average, for
+// Duration. The run goes on for at most Drain after that, until every
+// passenger has arrived.
+type Traffic struct {
+	Profile  Profile
+	Rate     float64
+	Duration time.Duration
+	Drain    time.Duration
+}
+
+// Passenger is someone who appeared at a floor, pressed the hall button
+// towards where they are going, and boarded the first car to open its door at
+// their floor, whichever way it goes next. In the car they press the cab
+// button of their floor.
+type Passenger struct {
+	From, To  int
+	Appeared  time.Duration
+	Boarded   time.Duration
+	Arrived   time.Duration
+	Car       int // Zero until boarded
+	IsArrived bool
+}
+
+// TrafficReport sums up a traffic run. Journey time is from appearing to
+// arriving. Stops and floors travelled are summed over the cars, floors being
+// a proxy for the energy used.
+type TrafficReport struct {
+	Passengers []*Passenger
+	Arrived    int
+	WaitAvg    time.Duration
+	WaitP95    time.Duration
+	JourneyAvg time.Duration
+	JourneyP95 time.Duration
+	Stops      int
+	Floors     int
+}
+
+// RunTraffic runs traffic on a new cluster of size nodes.
+func RunTraffic(size int, traffic Traffic, seed int64) *TrafficReport {
+	c := NewCluster(size)
+	defer c.Stop()
+
+	rnd := rand.New(rand.NewSource(seed))
+	r := &TrafficReport{}
+	nextArrival := func(t time.Duration) time.Duration {
+		return t + time.Duration(rnd.ExpFloat64()*float64(time.Minute)/traffic.Rate)
+	}
+	for next := nextArrival(0); ; {
+		for ; next < traffic.Duration && next <= c.Now(); next = nextArrival(next) {
+			from, to := traffic.Profile(rnd)
+			r.Passengers = append(r.Passengers, &Passenger{From: from, To: to, Appeared: c.Now()})
+			button := drivers.BT_HallUp
+			if to < from {
+				button = drivers.BT_HallDown
+			}
+			c.Members[rnd.Intn(size)].Hardware.Press(drivers.ButtonEvent{Floor: from, Button: button})
+		}
+		if c.Now() >= traffic.Duration && (r.Arrived == len(r.Passengers) || c.Now() >= traffic.Duration+traffic.Drain) {
+			break
+		}
+		c.Step()
+		r.movePassengers(c)
+	}
+
+	var waits, journeys []time.Duration
+	for _, p := range r.Passengers {
+		if p.IsArrived {
+			waits = append(waits, p.Boarded-p.Appeared)
+			journeys = append(journeys, p.Arrived-p.Appeared)
+		}
+	}
+	r.WaitAvg, r.WaitP95 = average(waits), percentile(waits, 0.95)
+	r.JourneyAvg, r.JourneyP95 = average(journeys), percentile(journeys, 0.95)
+	for _, m := range c.Members {
+		r.Stops += m.Hardware.Stops()
+		r.Floors += m.Hardware.FloorsTravelled()
+	}
+	return r
+}
+
+// movePassengers lets passengers off and on the cars with open doors.
+func (r *TrafficReport) movePassengers(c *Cluster) {
+	for _, m := range c.Members {
+		if m.Killed || !m.Hardware.DoorOpen() {
+			continue
+		}
+		floor := m.Hardware.Floor()
+		for _, p := range r.Passengers {
+			switch {
+			case p.Car == m.ID && !p.IsArrived && p.To == floor:
+				p.Arrived, p.IsArrived = c.Now(), true
+				r.Arrived++
+			case p.Car == 0 && p.From == floor:
+				p.Boarded, p.Car = c.Now(), m.ID
+				m.Hardware.Press(drivers.ButtonEvent{Floor: p.To, Button: drivers.BT_Cab})
+			}
+		}
+	}
+}
+
+func average(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	return sum / time.Duration(len(ds))
+}
+
+// percentile returns the smallest duration at least q of ds are at or below.
+func percentile(ds []time.Duration, q float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(math.Ceil(q*float64(len(sorted))))-1]
+}
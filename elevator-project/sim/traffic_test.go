@@ -0,0 +1,63 @@
+package sim
+
+import (
+	"elevator-project/pkg/config"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTrafficArrives(t *testing.T) {
+	traffic := Traffic{Profile: Profiles["uniform"], Rate: 6, Duration: time.Minute, Drain: 2 * time.Minute}
+	r := RunTraffic(3, traffic, 1)
+	if len(r.Passengers) == 0 {
+		t.Fatal("no passengers")
+	}
+	if r.Arrived != len(r.Passengers) {
+		t.Errorf("%d of %d passengers arrived", r.Arrived, len(r.Passengers))
+	}
+	if r.WaitAvg <= 0 || r.JourneyP95 < r.JourneyAvg || r.Stops == 0 || r.Floors == 0 {
+		t.Errorf("implausible report %+v", r)
+	}
+}
+
+// BenchmarkTraffic compares the assignment strategies on every traffic
+// profile and cluster size, e.g.
+//
+//	go test ./sim -run XXX -bench Traffic/uppeak -timeout=2h
+func BenchmarkTraffic(b *testing.B) {
+	defer func(assigner string) { config.HallAssigner = assigner }(config.HallAssigner)
+
+	traffic := Traffic{Rate: 8, Duration: 5 * time.Minute, Drain: 3 * time.Minute}
+	for _, profile := range []string{"uniform", "uppeak", "lunch", "downpeak"} {
+		for _, assigner := range []string{"native", "nearest"} {
+			for size := 1; size <= 4; size++ {
+				name := fmt.Sprintf("%s/%s/elevators=%d", profile, assigner, size)
+				b.Run(name, func(b *testing.B) {
+					config.HallAssigner = assigner
+					traffic.Profile = Profiles[profile]
+					var wait, waitP95, journey, journeyP95 time.Duration
+					var stops, floors, unserved int
+					for i := 0; i < b.N; i++ {
+						r := RunTraffic(size, traffic, int64(i+1))
+						wait += r.WaitAvg
+						waitP95 += r.WaitP95
+						journey += r.JourneyAvg
+						journeyP95 += r.JourneyP95
+						stops += r.Stops
+						floors += r.Floors
+						unserved += len(r.Passengers) - r.Arrived
+					}
+					n := float64(b.N)
+					b.ReportMetric(wait.Seconds()/n, "wait-s")
+					b.ReportMetric(waitP95.Seconds()/n, "wait-p95-s")
+					b.ReportMetric(journey.Seconds()/n, "journey-s")
+					b.ReportMetric(journeyP95.Seconds()/n, "journey-p95-s")
+					b.ReportMetric(float64(stops)/n, "stops")
+					b.ReportMetric(float64(floors)/n, "floors")
+					b.ReportMetric(float64(unserved)/n, "unserved")
+				})
+			}
+		}
+	}
+}
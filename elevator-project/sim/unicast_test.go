@@ -0,0 +1,75 @@
+package sim
+
+import (
+	"context"
+	"elevator-project/pkg/config"
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/message"
+	"elevator-project/pkg/network/bcast"
+	"sync"
+	"testing"
+	"time"
+)
+
+// With unicast, OrderDelegations and Acks reach the nodes they are for without
+// being broadcast, and hall calls are still served.
+func TestUnicastKeepsDirectedMessagesOffBroadcast(t *testing.T) {
+	defer func(unicast bool) { config.Unicast = unicast }(config.Unicast)
+	config.Unicast = true
+	c := NewCluster(3)
+	defer c.Stop()
+
+	// A node outside the cluster listening to the broadcasts.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sniffer := c.Network.Listen(99, config.BCport)
+	defer sniffer.Close()
+	broadcasts := make(chan message.Message)
+	go bcast.ReceiverOn(ctx, sniffer, broadcasts)
+	var mu sync.Mutex
+	seen := make(map[message.MessageType]int)
+	go func() {
+		for {
+			select {
+			case msg := <-broadcasts:
+				mu.Lock()
+				seen[msg.Type()]++
+				mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Snapshots go out every second, so an order taken within 300ms of the
+	// press came with the delegation.
+	call := drivers.ButtonEvent{Floor: 3, Button: drivers.BT_HallDown}
+	c.Advance(1200 * time.Millisecond)
+	c.Member(2).Hardware.Press(call)
+	c.Advance(300 * time.Millisecond)
+	taken := false
+	for _, m := range c.Members {
+		taken = taken || m.Node.Elevator().HallRequests()[call.Floor][call.Button]
+	}
+	if !taken {
+		t.Fatalf("no elevator has taken the hall call at %s", c.Now())
+	}
+
+	c.Advance(10 * time.Second)
+	for _, m := range c.Members {
+		if m.Node.Elevator().HallRequests()[call.Floor][call.Button] {
+			t.Errorf("elevator %d has not served the hall call at %s", m.ID, c.Now())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if seen[message.TypeButtonEvent] == 0 {
+		t.Errorf("no ButtonEvent broadcast seen, saw %v", seen)
+	}
+	for _, directed := range []message.MessageType{message.TypeOrderDelegation, message.TypeAck} {
+		if seen[directed] > 0 {
+			t.Errorf("%d %s messages were broadcast", seen[directed], directed)
+		}
+	}
+}
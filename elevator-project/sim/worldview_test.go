@@ -0,0 +1,29 @@
+package sim
+
+import (
+	"elevator-project/pkg/drivers"
+	"elevator-project/pkg/network/faults"
+	"testing"
+	"time"
+)
+
+// A node that misses a worldview delta asks for a keyframe, and does not wait
+// for the next periodic one to catch up.
+func TestMissedDeltaRecoveredByKeyframe(t *testing.T) {
+	c := NewCluster(3)
+	defer c.Stop()
+
+	// Keyframes go out at 100ms, 1.1s, 2.1s and so on.
+	c.Advance(1250 * time.Millisecond)
+	c.Member(2).Faults.Set(faults.Config{Loss: 100})
+	c.Member(2).Hardware.Press(drivers.ButtonEvent{Floor: 3, Button: drivers.BT_Cab})
+	c.Advance(350 * time.Millisecond)
+	c.Member(2).Faults.Set(faults.Config{})
+	c.Advance(350 * time.Millisecond)
+
+	for _, view := range c.Member(1).Node.DashboardSnapshot().Elevators {
+		if view.ID == 2 && !view.CabRequests[3] {
+			t.Errorf("node 1 does not know of the cab call in elevator 2 at %s", c.Now())
+		}
+	}
+}